@@ -9,6 +9,7 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // position of the node's first token
 }
 
 type Statement interface {
@@ -33,6 +34,13 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, s := range p.Statements {
@@ -45,12 +53,15 @@ type LetStatement struct {
 	Token token.Token // the token.LET token
 	Name  *Identifier
 	Value Expression
+	Doc   string // the comments directly above the statement
 }
 
 func (s *LetStatement) statementNode() {}
 
 func (s *LetStatement) TokenLiteral() string { return s.Token.Literal }
 
+func (s *LetStatement) Pos() token.Position { return s.Token.Pos }
+
 func (s *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(s.TokenLiteral() + " ")
@@ -72,6 +83,8 @@ func (s *ReturnStatement) statementNode() {}
 
 func (s *ReturnStatement) TokenLiteral() string { return s.Token.Literal }
 
+func (s *ReturnStatement) Pos() token.Position { return s.Token.Pos }
+
 func (s *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(s.TokenLiteral() + " ")
@@ -91,6 +104,8 @@ func (s *ExpressionStatement) statementNode() {}
 
 func (s *ExpressionStatement) TokenLiteral() string { return s.Token.Literal }
 
+func (s *ExpressionStatement) Pos() token.Position { return s.Token.Pos }
+
 func (s *ExpressionStatement) String() string {
 	if s.Expression != nil {
 		return s.Expression.String()
@@ -107,6 +122,8 @@ func (s *BlockStatement) statementNode() {}
 
 func (s *BlockStatement) TokenLiteral() string { return s.Token.Literal }
 
+func (s *BlockStatement) Pos() token.Position { return s.Token.Pos }
+
 func (s *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range s.Statements {
@@ -115,6 +132,75 @@ func (s *BlockStatement) String() string {
 	return out.String()
 }
 
+type WhileStatement struct {
+	Token     token.Token // the 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (s *WhileStatement) statementNode() {}
+
+func (s *WhileStatement) TokenLiteral() string { return s.Token.Literal }
+
+func (s *WhileStatement) Pos() token.Position { return s.Token.Pos }
+
+func (s *WhileStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("while")
+	out.WriteString(s.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(s.Body.String())
+	return out.String()
+}
+
+type ForInStatement struct {
+	Token    token.Token // the 'for' token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (s *ForInStatement) statementNode() {}
+
+func (s *ForInStatement) TokenLiteral() string { return s.Token.Literal }
+
+func (s *ForInStatement) Pos() token.Position { return s.Token.Pos }
+
+func (s *ForInStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("for(")
+	out.WriteString(s.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(s.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(s.Body.String())
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // the 'break' token
+}
+
+func (s *BreakStatement) statementNode() {}
+
+func (s *BreakStatement) TokenLiteral() string { return s.Token.Literal }
+
+func (s *BreakStatement) Pos() token.Position { return s.Token.Pos }
+
+func (s *BreakStatement) String() string { return s.TokenLiteral() + ";" }
+
+type ContinueStatement struct {
+	Token token.Token // the 'continue' token
+}
+
+func (s *ContinueStatement) statementNode() {}
+
+func (s *ContinueStatement) TokenLiteral() string { return s.Token.Literal }
+
+func (s *ContinueStatement) Pos() token.Position { return s.Token.Pos }
+
+func (s *ContinueStatement) String() string { return s.TokenLiteral() + ";" }
+
 type Identifier struct {
 	Token token.Token // the token.IDENT token
 	Value string
@@ -124,6 +210,8 @@ func (i *Identifier) expressionNode() {}
 
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+
 func (i *Identifier) String() string { return i.Value }
 
 type IntegerLiteral struct {
@@ -135,8 +223,23 @@ func (i *IntegerLiteral) expressionNode() {}
 
 func (i *IntegerLiteral) TokenLiteral() string { return i.Token.Literal }
 
+func (i *IntegerLiteral) Pos() token.Position { return i.Token.Pos }
+
 func (i *IntegerLiteral) String() string { return i.Token.Literal }
 
+type FloatLiteral struct {
+	Token token.Token // the token.FLOAT token
+	Value float64
+}
+
+func (f *FloatLiteral) expressionNode() {}
+
+func (f *FloatLiteral) TokenLiteral() string { return f.Token.Literal }
+
+func (f *FloatLiteral) Pos() token.Position { return f.Token.Pos }
+
+func (f *FloatLiteral) String() string { return f.Token.Literal }
+
 type PrefixExpression struct {
 	Token    token.Token // the prefix token, eg. !
 	Operator string
@@ -147,6 +250,8 @@ func (e *PrefixExpression) expressionNode() {}
 
 func (e *PrefixExpression) TokenLiteral() string { return e.Token.Literal }
 
+func (e *PrefixExpression) Pos() token.Position { return e.Token.Pos }
+
 func (e *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -167,6 +272,8 @@ func (e *InfixExpression) expressionNode() {}
 
 func (e *InfixExpression) TokenLiteral() string { return e.Token.Literal }
 
+func (e *InfixExpression) Pos() token.Position { return e.Token.Pos }
+
 func (e *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -177,6 +284,28 @@ func (e *InfixExpression) String() string {
 	return out.String()
 }
 
+type AssignExpression struct {
+	Token  token.Token // the = token
+	Target Expression  // Identifier or IndexExpression
+	Value  Expression
+}
+
+func (e *AssignExpression) expressionNode() {}
+
+func (e *AssignExpression) TokenLiteral() string { return e.Token.Literal }
+
+func (e *AssignExpression) Pos() token.Position { return e.Token.Pos }
+
+func (e *AssignExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(e.Target.String())
+	out.WriteString(" = ")
+	out.WriteString(e.Value.String())
+	out.WriteString(")")
+	return out.String()
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -186,6 +315,8 @@ func (b *Boolean) expressionNode() {}
 
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 
+func (b *Boolean) Pos() token.Position { return b.Token.Pos }
+
 func (b *Boolean) String() string { return b.Token.Literal }
 
 type IfExpression struct {
@@ -199,6 +330,8 @@ func (e *IfExpression) expressionNode() {}
 
 func (e *IfExpression) TokenLiteral() string { return e.Token.Literal }
 
+func (e *IfExpression) Pos() token.Position { return e.Token.Pos }
+
 func (e *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if")
@@ -216,12 +349,15 @@ type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Name       string // name of the let binding the function is assigned to, if any
 }
 
 func (f *FunctionLiteral) expressionNode() {}
 
 func (f *FunctionLiteral) TokenLiteral() string { return f.Token.Literal }
 
+func (f *FunctionLiteral) Pos() token.Position { return f.Token.Pos }
+
 func (f *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
@@ -246,6 +382,8 @@ func (e *CallExpression) expressionNode() {}
 
 func (e *CallExpression) TokenLiteral() string { return e.Token.Literal }
 
+func (e *CallExpression) Pos() token.Position { return e.Token.Pos }
+
 func (e *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -268,6 +406,8 @@ func (s *StringLiteral) expressionNode() {}
 
 func (s *StringLiteral) TokenLiteral() string { return s.Token.Literal }
 
+func (s *StringLiteral) Pos() token.Position { return s.Token.Pos }
+
 func (s *StringLiteral) String() string { return s.Token.Literal }
 
 type ArrayLiteral struct {
@@ -279,6 +419,8 @@ func (a *ArrayLiteral) expressionNode() {}
 
 func (a *ArrayLiteral) TokenLiteral() string { return a.Token.Literal }
 
+func (a *ArrayLiteral) Pos() token.Position { return a.Token.Pos }
+
 func (a *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -297,18 +439,21 @@ func (a *ArrayLiteral) String() string {
 type HashLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
+	Keys  []Expression // the keys of Pairs in source order
 }
 
 func (h *HashLiteral) expressionNode() {}
 
 func (h *HashLiteral) TokenLiteral() string { return h.Token.Literal }
 
+func (h *HashLiteral) Pos() token.Position { return h.Token.Pos }
+
 func (h *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for k, v := range h.Pairs {
-		pairs = append(pairs, k.String()+":"+v.String())
+	for _, k := range h.Keys {
+		pairs = append(pairs, k.String()+":"+h.Pairs[k].String())
 	}
 
 	out.WriteString("{")
@@ -328,6 +473,8 @@ func (m *MacroLiteral) expressionNode() {}
 
 func (m *MacroLiteral) TokenLiteral() string { return m.Token.Literal }
 
+func (m *MacroLiteral) Pos() token.Position { return m.Token.Pos }
+
 func (m *MacroLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
@@ -354,6 +501,8 @@ func (e *IndexExpression) expressionNode() {}
 
 func (e *IndexExpression) TokenLiteral() string { return e.Token.Literal }
 
+func (e *IndexExpression) Pos() token.Position { return e.Token.Pos }
+
 func (e *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -13,6 +13,9 @@ func Modify(node Node, modifier ModifierFunc) Node {
 	case *InfixExpression:
 		node.Left, _ = Modify(node.Left, modifier).(Expression)
 		node.Right, _ = Modify(node.Right, modifier).(Expression)
+	case *AssignExpression:
+		node.Target, _ = Modify(node.Target, modifier).(Expression)
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
 	case *PrefixExpression:
 		node.Right, _ = Modify(node.Right, modifier).(Expression)
 	case *IndexExpression:
@@ -28,6 +31,12 @@ func Modify(node Node, modifier ModifierFunc) Node {
 		for i, _ := range node.Statements {
 			node.Statements[i], _ = Modify(node.Statements[i], modifier).(Statement)
 		}
+	case *WhileStatement:
+		node.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+	case *ForInStatement:
+		node.Iterable, _ = Modify(node.Iterable, modifier).(Expression)
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
 	case *ReturnStatement:
 		node.ReturnValue, _ = Modify(node.ReturnValue, modifier).(Expression)
 	case *LetStatement:
@@ -43,12 +52,17 @@ func Modify(node Node, modifier ModifierFunc) Node {
 		}
 	case *HashLiteral:
 		newPairs := make(map[Expression]Expression)
+		newKeys := make(map[Expression]Expression)
 		for key, val := range node.Pairs {
 			newKey, _ := Modify(key, modifier).(Expression)
 			newVal, _ := Modify(val, modifier).(Expression)
 			newPairs[newKey] = newVal
+			newKeys[key] = newKey
 		}
 		node.Pairs = newPairs
+		for i, key := range node.Keys {
+			node.Keys[i] = newKeys[key]
+		}
 	}
 
 	return modifier(node)
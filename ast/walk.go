@@ -0,0 +1,75 @@
+package ast
+
+// Inspect traverses the tree of node in depth-first order, calling f for
+// each node before its children. It skips the children of the nodes for
+// which f returns false.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	switch node := node.(type) {
+	case *Program:
+		for _, s := range node.Statements {
+			Inspect(s, f)
+		}
+	case *LetStatement:
+		Inspect(node.Name, f)
+		Inspect(node.Value, f)
+	case *ReturnStatement:
+		Inspect(node.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(node.Expression, f)
+	case *BlockStatement:
+		for _, s := range node.Statements {
+			Inspect(s, f)
+		}
+	case *WhileStatement:
+		Inspect(node.Condition, f)
+		Inspect(node.Body, f)
+	case *ForInStatement:
+		Inspect(node.Variable, f)
+		Inspect(node.Iterable, f)
+		Inspect(node.Body, f)
+	case *PrefixExpression:
+		Inspect(node.Right, f)
+	case *InfixExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Right, f)
+	case *AssignExpression:
+		Inspect(node.Target, f)
+		Inspect(node.Value, f)
+	case *IfExpression:
+		Inspect(node.Condition, f)
+		Inspect(node.Consequence, f)
+		if node.Alternative != nil {
+			Inspect(node.Alternative, f)
+		}
+	case *FunctionLiteral:
+		for _, p := range node.Parameters {
+			Inspect(p, f)
+		}
+		Inspect(node.Body, f)
+	case *MacroLiteral:
+		for _, p := range node.Parameters {
+			Inspect(p, f)
+		}
+		Inspect(node.Body, f)
+	case *CallExpression:
+		Inspect(node.Function, f)
+		for _, a := range node.Arguments {
+			Inspect(a, f)
+		}
+	case *ArrayLiteral:
+		for _, e := range node.Elements {
+			Inspect(e, f)
+		}
+	case *HashLiteral:
+		for _, key := range node.Keys {
+			Inspect(key, f)
+			Inspect(node.Pairs[key], f)
+		}
+	case *IndexExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Index, f)
+	}
+}
@@ -0,0 +1,47 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	// let f = fn(x) { g(x, [y]); if (z) { a } else { b } }
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Name: ident("f"),
+				Value: &FunctionLiteral{
+					Parameters: []*Identifier{ident("x")},
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&ExpressionStatement{Expression: &CallExpression{
+								Function:  ident("g"),
+								Arguments: []Expression{ident("x"), &ArrayLiteral{Elements: []Expression{ident("y")}}},
+							}},
+							&ExpressionStatement{Expression: &IfExpression{
+								Condition:   ident("z"),
+								Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: ident("a")}}},
+								Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: ident("b")}}},
+							}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var names []string
+	Inspect(program, func(node Node) bool {
+		if ident, ok := node.(*Identifier); ok {
+			names = append(names, ident.Value)
+		}
+		_, isIf := node.(*IfExpression)
+		return !isIf
+	})
+	expected := []string{"f", "x", "g", "x", "y"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("wrong identifiers. want=%v, got=%v", expected, names)
+	}
+}
@@ -0,0 +1,36 @@
+package code
+
+import "monkey/token"
+
+// PositionEntry records that the instructions starting at Offset were
+// compiled from source at Pos.
+type PositionEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// PositionTable maps instruction offsets back to source positions. Entries
+// are sorted by Offset; an entry covers every offset up to the next one.
+type PositionTable []PositionEntry
+
+// Lookup returns the source position of the instruction at offset.
+func (t PositionTable) Lookup(offset int) token.Position {
+	pos := token.Position{}
+	for _, e := range t {
+		if e.Offset > offset {
+			break
+		}
+		pos = e.Pos
+	}
+	return pos
+}
+
+// Truncate drops the entries for offsets at or beyond length.
+func (t PositionTable) Truncate(length int) PositionTable {
+	for i, e := range t {
+		if e.Offset >= length {
+			return t[:i]
+		}
+	}
+	return t
+}
@@ -5,7 +5,7 @@ import (
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
-	"sort"
+	"monkey/token"
 )
 
 type Compiler struct {
@@ -13,6 +13,11 @@ type Compiler struct {
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+	pos         token.Position // source position of the node being compiled
+
+	// Optimize enables constant folding, pruning of if branches with
+	// constant conditions and removal of jumps to the next instruction.
+	Optimize bool
 }
 
 type EmittedInstruction struct {
@@ -24,20 +29,27 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	positions           code.PositionTable
+	loops               []*loopContext
+	operands            int // values on the stack for an instruction not emitted yet
+}
+
+// loopContext tracks the jump targets of the innermost loop being compiled.
+type loopContext struct {
+	continuePos int   // where continue jumps to
+	breakJumps  []int // positions of the jumps emitted for break, patched at loop end
+	operands    int   // operands of the scope at the loop, which break and continue keep
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Positions    code.PositionTable
 }
 
 func New() *Compiler {
 
-	symbolTable := NewSymbolTable()
-
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable := NewSymbolTableWithBuiltins(object.Builtins)
 
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
@@ -61,6 +73,12 @@ func NewWithState(symbolTable *SymbolTable, constants []object.Object) *Compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if node != nil && node.Pos().IsValid() {
+		outerPos := c.pos
+		c.pos = node.Pos()
+		defer func() { c.pos = outerPos }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -83,16 +101,75 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.LetStatement:
+		// a function is defined before its body is compiled, since it can
+		// refer to itself
+		_, isFunction := node.Value.(*ast.FunctionLiteral)
+		var symbol Symbol
+		if isFunction {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		symbol := c.symbolTable.Define(node.Name.Value)
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		if !isFunction {
+			symbol = c.symbolTable.Define(node.Name.Value)
 		}
+		c.storeSymbol(symbol)
+	case *ast.WhileStatement:
+		conditionPos := len(c.currentInstructions())
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+		// a break in the condition belongs to an enclosing loop
+		loop := c.enterLoop(conditionPos)
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999) // with bogus value
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loop.continuePos)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.leaveLoop()
+	case *ast.ForInStatement:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIterator)
+		// the iterator lives in a hidden variable no identifier can refer to,
+		// one per loop nesting depth so that sibling loops share the slot
+		iterator := c.symbolTable.Define(fmt.Sprintf("$iterator%d", len(c.scopes[c.scopeIndex].loops)))
+		c.storeSymbol(iterator)
+		variable := c.symbolTable.Define(node.Variable.Value)
+
+		loop := c.enterLoop(len(c.currentInstructions()))
+		c.loadSymbol(iterator)
+		c.emit(code.OpIterNext)
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999) // with bogus value
+		c.storeSymbol(variable)
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loop.continuePos)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.leaveLoop()
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: break outside of loop", node.Pos())
+		}
+		c.popOperands(loop)
+		loop.breakJumps = append(loop.breakJumps, c.emit(code.OpJump, 9999)) // with bogus value
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: continue outside of loop", node.Pos())
+		}
+		c.popOperands(loop)
+		c.emit(code.OpJump, loop.continuePos)
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
@@ -100,21 +177,15 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpReturnValue)
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			if err := c.Compile(node.Right); err != nil {
-				return err
-			}
-			if err := c.Compile(node.Left); err != nil {
-				return err
-			}
-		} else {
-			if err := c.Compile(node.Left); err != nil {
-				return err
-			}
-			if err := c.Compile(node.Right); err != nil {
-				return err
+		if c.Optimize {
+			if obj, ok := foldConstant(node); ok {
+				c.emitFolded(obj)
+				return nil
 			}
 		}
+		if err := c.compileOperands(node.Left, node.Right); err != nil {
+			return err
+		}
 		switch node.Operator {
 		case "+":
 			c.emit(code.OpAdd)
@@ -127,15 +198,21 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case ">":
 			c.emit(code.OpGreaterThan)
 		case "<":
-			c.emit(code.OpGreaterThan)
+			c.emit(code.OpLessThan)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 	case *ast.PrefixExpression:
+		if c.Optimize {
+			if obj, ok := foldConstant(node); ok {
+				c.emitFolded(obj)
+				return nil
+			}
+		}
 		if err := c.Compile(node.Right); err != nil {
 			return err
 		}
@@ -145,9 +222,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
+	case *ast.AssignExpression:
+		return c.compileAssignment(node)
 	case *ast.IfExpression:
+		if c.Optimize {
+			if condition, ok := foldConstant(node.Condition); ok {
+				return c.compileTakenBranch(node, isTruthy(condition))
+			}
+		}
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -157,9 +241,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
+		c.removeLastPopOrEmitNull()
 		jumpPos := c.emit(code.OpJump, 9999) // with bogus value
 		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
@@ -168,9 +250,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+			c.removeLastPopOrEmitNull()
 		} else {
 			c.emit(code.OpNull)
 		}
@@ -179,6 +259,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -191,37 +274,38 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return fmt.Errorf("%s: undefined variable %s", node.Pos(), node.Value)
 		}
 		c.loadSymbol(symbol)
 	case *ast.ArrayLiteral:
-		for _, el := range node.Elements {
-			err := c.Compile(el)
-			if err != nil {
-				return err
-			}
+		elements := make([]ast.Node, len(node.Elements))
+		for i, el := range node.Elements {
+			elements[i] = el
+		}
+		if err := c.compileOperands(elements...); err != nil {
+			return err
 		}
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
+		pairs := make([]ast.Node, 0, len(node.Keys)*2)
+		for _, k := range node.Keys {
+			pairs = append(pairs, k, node.Pairs[k])
 		}
-		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
-		for _, k := range keys {
-			err := c.Compile(k)
-			if err != nil {
-				return err
-			}
-			err = c.Compile(node.Pairs[k])
-			if err != nil {
-				return err
-			}
+		if err := c.compileOperands(pairs...); err != nil {
+			return err
 		}
-		c.emit(code.OpHash, len(node.Pairs)*2)
+		c.emit(code.OpHash, len(pairs))
 	case *ast.FunctionLiteral:
+		// a local function refers to itself as the closure being executed,
+		// rather than capturing the variable it is still to be stored in;
+		// a global one, or one assigning to its name, uses the variable
+		local := c.symbolTable.Outer != nil
 		c.enterScope()
 
+		if node.Name != "" && local && !assignsTo(node.Body, node.Name) {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
 		for _, p := range node.Parameters {
 			c.symbolTable.Define(p.Value)
 		}
@@ -238,43 +322,125 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		positions := c.currentPositions()
 		instructions := c.leaveScope()
+		instructions, positions = markTailCalls(instructions, positions)
+		if c.Optimize {
+			instructions, positions = removeJumpsToNext(instructions, positions)
+		}
 		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+			c.loadCapturedSymbol(s)
 		}
 		compiledFn := &object.CompiledFunction{
+			Name:          node.Name,
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Positions:     positions,
 		}
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 	case *ast.IndexExpression:
-		err := c.Compile(node.Left)
-		if err != nil {
-			return err
-		}
-		err = c.Compile(node.Index)
-		if err != nil {
+		if err := c.compileOperands(node.Left, node.Index); err != nil {
 			return err
 		}
 		c.emit(code.OpIndex)
+	case *ast.MacroLiteral:
+		return fmt.Errorf("%s: macro literal is only allowed as the value of a top-level let; run macro expansion before compiling", node.Pos())
 	case *ast.CallExpression:
-		err := c.Compile(node.Function)
-		if err != nil {
-			return err
+		if name := node.Function.TokenLiteral(); name == "quote" || name == "unquote" {
+			return fmt.Errorf("%s: %s is not supported outside of a macro body", node.Pos(), name)
 		}
+		operands := []ast.Node{node.Function}
 		for _, a := range node.Arguments {
-			err := c.Compile(a)
-			if err != nil {
-				return err
-			}
+			operands = append(operands, a)
+		}
+		if err := c.compileOperands(operands...); err != nil {
+			return err
 		}
 		c.emit(code.OpCall, len(node.Arguments))
 	}
 	return nil
 }
 
+func (c *Compiler) compileAssignment(node *ast.AssignExpression) error {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return fmt.Errorf("%s: undefined variable %s", target.Pos(), target.Value)
+		}
+		if symbol.Scope == BuiltinScope || symbol.Scope == FunctionScope {
+			return fmt.Errorf("%s: cannot assign to %s", target.Pos(), target.Value)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		if err := c.compileOperands(target.Left, target.Index, node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+	return nil
+}
+
+// assignsTo reports whether node assigns to a variable called name.
+func assignsTo(node ast.Node, name string) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if assign, ok := n.(*ast.AssignExpression); ok {
+			if ident, ok := assign.Target.(*ast.Identifier); ok && ident.Value == name {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// compileOperands compiles nodes whose values stay on the stack until the
+// instruction emitted next, counting them for break and continue.
+func (c *Compiler) compileOperands(nodes ...ast.Node) error {
+	base := c.scopes[c.scopeIndex].operands
+	defer func() { c.scopes[c.scopeIndex].operands = base }()
+	for i, node := range nodes {
+		c.scopes[c.scopeIndex].operands = base + i
+		if err := c.Compile(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileTakenBranch compiles only the branch of an if expression that its
+// constant condition selects.
+func (c *Compiler) compileTakenBranch(node *ast.IfExpression, truthy bool) error {
+	branch := node.Alternative
+	if truthy {
+		branch = node.Consequence
+	}
+	if branch == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+	start := len(c.currentInstructions())
+	if err := c.Compile(branch); err != nil {
+		return err
+	}
+	if len(c.currentInstructions()) == start {
+		// the last instruction is not the branch's, so it may not be removed
+		c.emit(code.OpNull)
+	} else {
+		c.removeLastPopOrEmitNull()
+	}
+	return nil
+}
+
 func (c *Compiler) addConstant(obj object.Object) int {
 	c.constants = append(c.constants, obj)
 	return len(c.constants) - 1
@@ -284,9 +450,23 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
 	c.setLastInstruction(op, pos)
+	c.addPosition(pos)
 	return pos
 }
 
+// addPosition records the source position of the instruction emitted at
+// offset, unless it is the same as the one already in effect.
+func (c *Compiler) addPosition(offset int) {
+	if !c.pos.IsValid() {
+		return
+	}
+	positions := c.currentPositions()
+	if len(positions) > 0 && positions[len(positions)-1].Pos == c.pos {
+		return
+	}
+	c.scopes[c.scopeIndex].positions = append(positions, code.PositionEntry{Offset: offset, Pos: c.pos})
+}
+
 func (c *Compiler) addInstruction(ins []byte) int {
 	posNewInstruction := len(c.currentInstructions())
 	updatedInstructions := append(c.currentInstructions(), ins...)
@@ -305,6 +485,10 @@ func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
 
+func (c *Compiler) currentPositions() code.PositionTable {
+	return c.scopes[c.scopeIndex].positions
+}
+
 func (c *Compiler) removeLastPop() {
 	last := c.scopes[c.scopeIndex].lastInstruction
 	previous := c.scopes[c.scopeIndex].previousInstruction
@@ -312,6 +496,17 @@ func (c *Compiler) removeLastPop() {
 	new := old[:last.Position]
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].positions = c.currentPositions().Truncate(last.Position)
+}
+
+// removeLastPopOrEmitNull leaves the value of a block on the stack: the value
+// of its last expression statement, or null if it ends with another statement.
+func (c *Compiler) removeLastPopOrEmitNull() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -328,9 +523,14 @@ func (c *Compiler) changeOperand(opPos int, operand int) {
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions, positions := c.currentInstructions(), c.currentPositions()
+	if c.Optimize {
+		instructions, positions = removeJumpsToNext(instructions, positions)
+	}
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
+		Positions:    positions,
 	}
 }
 
@@ -366,6 +566,66 @@ func (c *Compiler) replaceLastPopWithReturn() {
 	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
 
+func (c *Compiler) enterLoop(continuePos int) *loopContext {
+	loop := &loopContext{
+		continuePos: continuePos,
+		operands:    c.scopes[c.scopeIndex].operands,
+	}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+	return loop
+}
+
+func (c *Compiler) leaveLoop() {
+	loops := c.scopes[c.scopeIndex].loops
+	loop := loops[len(loops)-1]
+	afterLoopPos := len(c.currentInstructions())
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, afterLoopPos)
+	}
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
+// popOperands pops the operands pushed since loop started, which a break
+// or continue in the middle of an expression leaves on the stack.
+func (c *Compiler) popOperands(loop *loopContext) {
+	for i := loop.operands; i < c.scopes[c.scopeIndex].operands; i++ {
+		c.emit(code.OpPop)
+	}
+}
+
+func (c *Compiler) currentLoop() *loopContext {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// loadCapturedSymbol pushes the variable a new closure captures: the cell of
+// a local or free variable, so that assignments are shared, or the closure
+// itself for its own name.
+func (c *Compiler) loadCapturedSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpGetLocalCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFreeCell, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -376,5 +636,7 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
 		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
 	}
 }
@@ -14,10 +14,21 @@ type compilerTestCase struct {
 	input                string
 	expectedConstants    []interface{}
 	expectedInstructions []code.Instructions
+	optimize             bool
 }
 
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []compilerTestCase{
+		{
+			input:             "1.5 * 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "1 + 2",
 			expectedConstants: []interface{}{1, 2},
@@ -111,11 +122,11 @@ func TestBooleanExpression(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
@@ -371,6 +382,19 @@ func TestHashLiterals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			// pairs are compiled in source order, which the hash keeps
+			input:             "{3: 4, 1: 2}",
+			expectedConstants: []interface{}{3, 4, 1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 	runCompilerTests(t, tests)
 }
@@ -764,7 +788,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -795,7 +819,7 @@ func TestClosure(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -825,13 +849,13 @@ func TestClosure(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -854,7 +878,6 @@ func TestClosure(t *testing.T) {
 		 				}
 		 			}
 		 		}
-		 	}
 		 	`,
 			expectedConstants: []interface{}{
 				55,
@@ -876,15 +899,15 @@ func TestClosure(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -894,18 +917,357 @@ func TestClosure(t *testing.T) {
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpClosure, 6, 0),
 				code.Make(code.OpPop),
-				code.Make(code.OpPop), // ?????
 			},
 		},
 	}
 	runCompilerTests(t, tests)
 }
 
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `while (true) { if (false) { break; } else { continue; } } 1;`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 23),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 15),
+				// 0008
+				code.Make(code.OpJump, 23),
+				// 0011
+				code.Make(code.OpNull),
+				// 0012
+				code.Make(code.OpJump, 19),
+				// 0015
+				code.Make(code.OpJump, 0),
+				// 0018
+				code.Make(code.OpNull),
+				// 0019
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpJump, 0),
+				// 0023
+				code.Make(code.OpConstant, 0),
+				// 0026
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `while (true) { 1 + [2, if (true) { break; }] }`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 32),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpConstant, 1),
+				// 0010
+				code.Make(code.OpTrue),
+				// 0011
+				code.Make(code.OpJumpNotTruthy, 23),
+				// 0014: the operands 1 and 2 are popped before breaking
+				code.Make(code.OpPop),
+				// 0015
+				code.Make(code.OpPop),
+				// 0016
+				code.Make(code.OpJump, 32),
+				// 0019
+				code.Make(code.OpNull),
+				// 0020
+				code.Make(code.OpJump, 24),
+				// 0023
+				code.Make(code.OpNull),
+				// 0024
+				code.Make(code.OpArray, 2),
+				// 0027
+				code.Make(code.OpAdd),
+				// 0028
+				code.Make(code.OpPop),
+				// 0029
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input:             `for (x in [1]) { x }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIterator),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpGetGlobal, 0),
+				// 0013
+				code.Make(code.OpIterNext),
+				// 0014
+				code.Make(code.OpJumpNotTruthy, 27),
+				// 0017
+				code.Make(code.OpSetGlobal, 1),
+				// 0020
+				code.Make(code.OpGetGlobal, 1),
+				// 0023
+				code.Make(code.OpPop),
+				// 0024
+				code.Make(code.OpJump, 10),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let a = 1; a = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let a = 1; fn() { a = 2 } }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = [1]; a[0] = 2;",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `let f = fn(x) { if (x) { f(x) } else { 1 + f(x) } };`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 13),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					// the jump over the alternative became the return
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: `let f = fn(g) { return g(); };`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let countDown = fn(x) { countDown(x - 1); };
+			countDown(1);
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					// a global function refers to itself through its variable
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) { countDown(x - 1); };
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1;\n  y;", "2:3: undefined variable y"},
+		{"fn() {\n  1 + z\n}", "2:7: undefined variable z"},
+		{"quote(1 + 2)", "1:6: quote is not supported outside of a macro body"},
+		{"let f = fn(x) { unquote(x) };", "1:24: unquote is not supported outside of a macro body"},
+		{"x = 1;", "1:1: undefined variable x"},
+		{"len = 1;", "1:1: cannot assign to len"},
+		{"puts(macro(x) { x })", "1:6: macro literal is only allowed as the value of a top-level let; run macro expansion before compiling"},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestInstructionPositions(t *testing.T) {
+	input := `let x = 1;
+let f = fn() {
+  x + 2
+};`
+	program := parse(input)
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	// OpConstant 0 (3 bytes), OpSetGlobal 0 (3 bytes), OpClosure ...
+	if pos := bytecode.Positions.Lookup(0); pos.Line != 1 || pos.Column != 9 {
+		t.Errorf("wrong position of first instruction. got=%s", pos)
+	}
+	if pos := bytecode.Positions.Lookup(6); pos.Line != 2 || pos.Column != 9 {
+		t.Errorf("wrong position of closure instruction. got=%s", pos)
+	}
+
+	fn, ok := bytecode.Constants[2].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 2 is not a function: %T", bytecode.Constants[2])
+	}
+	// OpGetGlobal 0 (3 bytes), OpConstant 2 (3 bytes), OpAdd, OpReturnValue
+	// where the return replaces the statement's OpPop
+	expected := []string{"3:3", "3:3", "3:3", "3:7", "3:7", "3:7", "3:5", "3:3"}
+	for offset, want := range expected {
+		if got := fn.Positions.Lookup(offset).String(); got != want {
+			t.Errorf("wrong position at offset %d. want=%s, got=%s", offset, want, got)
+		}
+	}
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
 	for _, tt := range tests {
-		program := parse(tt.input)
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
 		compiler := New()
+		compiler.Optimize = tt.optimize
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -961,6 +1323,11 @@ func testConstants(t *testing.T, expected []interface{}, actual []object.Object)
 			if err != nil {
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s", i, err)
 			}
+		case float64:
+			float, ok := actual[i].(*object.Float)
+			if !ok || float.Value != constant {
+				return fmt.Errorf("constant %d - not Float %g. got=%T(%+v)", i, constant, actual[i], actual[i])
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
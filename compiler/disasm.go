@@ -0,0 +1,107 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/code"
+	"monkey/object"
+	"strconv"
+)
+
+// Disassemble returns a listing of b: the main instructions, the constant
+// pool, then the instructions of every compiled function in the pool under
+// its own label. Operands are annotated with what they refer to.
+func Disassemble(b *Bytecode) string {
+	var out bytes.Buffer
+	out.WriteString("main:\n")
+	disassembleInstructions(&out, b.Instructions, b.Constants)
+
+	if len(b.Constants) > 0 {
+		out.WriteString("\nconstants:\n")
+		for i, c := range b.Constants {
+			fmt.Fprintf(&out, "  %d: %s\n", i, describeConstant(i, c))
+		}
+	}
+
+	for i, c := range b.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			fmt.Fprintf(&out, "\n%s (params=%d, locals=%d):\n", functionLabel(i, fn), fn.NumParameters, fn.NumLocals)
+			disassembleInstructions(&out, fn.Instructions, b.Constants)
+		}
+	}
+	return out.String()
+}
+
+func disassembleInstructions(out *bytes.Buffer, ins code.Instructions, constants []object.Object) {
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(out, "  %04d ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			fmt.Fprintf(out, "  %04d ERROR: truncated %s\n", i, def.Name)
+			return
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		line := def.Name
+		for _, o := range operands {
+			line += " " + strconv.Itoa(o)
+		}
+		if note := annotate(code.Opcode(ins[i]), operands, constants); note != "" {
+			fmt.Fprintf(out, "  %04d %-24s ; %s\n", i, line, note)
+		} else {
+			fmt.Fprintf(out, "  %04d %s\n", i, line)
+		}
+		i += 1 + read
+	}
+}
+
+// annotate describes what the operands of an instruction refer to, if that
+// is more than a plain number.
+func annotate(op code.Opcode, operands []int, constants []object.Object) string {
+	switch op {
+	case code.OpConstant, code.OpClosure:
+		if operands[0] >= len(constants) {
+			return "invalid constant"
+		}
+		note := describeConstant(operands[0], constants[operands[0]])
+		if op == code.OpClosure && operands[1] > 0 {
+			note += fmt.Sprintf(", %d free", operands[1])
+		}
+		return note
+	case code.OpGetBuiltin:
+		if b := object.Builtins.At(operands[0]); b != nil {
+			return b.Name
+		}
+		return "invalid builtin"
+	case code.OpJump, code.OpJumpNotTruthy:
+		return fmt.Sprintf("-> %04d", operands[0])
+	}
+	return ""
+}
+
+func describeConstant(index int, obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return strconv.Quote(obj.Value)
+	case *object.CompiledFunction:
+		return functionLabel(index, obj)
+	default:
+		return obj.Inspect()
+	}
+}
+
+func functionLabel(index int, fn *object.CompiledFunction) string {
+	name := fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	return fmt.Sprintf("fn#%d %s", index, name)
+}
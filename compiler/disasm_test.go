@@ -0,0 +1,72 @@
+package compiler
+
+import (
+	"monkey/code"
+	"monkey/object"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let double = fn(x) { x * 2 }; if (len("ab") > 1) { double(1.5) } else { fn() { double } }`
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `main:
+  0000 OpClosure 1 0            ; fn#1 double
+  0004 OpSetGlobal 0
+  0007 OpGetBuiltin 0           ; len
+  0009 OpConstant 2             ; "ab"
+  0012 OpCall 1
+  0014 OpConstant 3             ; 1
+  0017 OpGraterThan
+  0018 OpJumpNotTruty 32        ; -> 0032
+  0021 OpGetGlobal 0
+  0024 OpConstant 4             ; 1.5
+  0027 OpCall 1
+  0029 OpJump 36                ; -> 0036
+  0032 OpClosure 5 0            ; fn#5 <anonymous>
+  0036 OpPop
+
+constants:
+  0: 2
+  1: fn#1 double
+  2: "ab"
+  3: 1
+  4: 1.5
+  5: fn#5 <anonymous>
+
+fn#1 double (params=1, locals=1):
+  0000 OpGetLocal 0
+  0002 OpConstant 0             ; 2
+  0005 OpMul
+  0006 OpReturnValue
+
+fn#5 <anonymous> (params=0, locals=0):
+  0000 OpGetGlobal 0
+  0003 OpReturnValue
+`
+	if actual := Disassemble(compiler.Bytecode()); actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestDisassembleInvalidInstructions(t *testing.T) {
+	bytecode := &Bytecode{
+		Instructions: append(code.Make(code.OpConstant, 3), 255, byte(code.OpGetBuiltin), 200, byte(code.OpJump), 0),
+		Constants:    []object.Object{&object.Integer{Value: 1}},
+	}
+	expected := `main:
+  0000 OpConstant 3             ; invalid constant
+  0003 ERROR: opcode 255 undefined
+  0004 OpGetBuiltin 200         ; invalid builtin
+  0006 ERROR: truncated OpJump
+
+constants:
+  0: 1
+`
+	if actual := Disassemble(bytecode); actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
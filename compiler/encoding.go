@@ -0,0 +1,247 @@
+package compiler
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+)
+
+// Magic starts every serialized Bytecode, followed by the format version.
+const Magic = "MNKY"
+
+// FormatVersion is bumped on every incompatible change to the encoding,
+// including changes to the instruction set.
+const FormatVersion uint16 = 2
+
+// ErrNotBytecode is returned by Decode for input without the Magic header.
+var ErrNotBytecode = errors.New("not a Monkey bytecode file")
+
+// constant tags of the serialized constant pool
+const (
+	tagInteger byte = iota + 1
+	tagFloat
+	tagString
+	tagFunction
+)
+
+// Encode writes b in the versioned binary format read by Decode. All
+// integers are big-endian; strings and instructions are prefixed with their
+// length as a uint32.
+func (b *Bytecode) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	e := &encoder{w: bw}
+	e.write([]byte(Magic))
+	e.uint16(FormatVersion)
+	e.bytes(b.Instructions)
+	e.positions(b.Positions)
+	e.uint32(len(b.Constants))
+	for _, c := range b.Constants {
+		e.constant(c)
+	}
+	if e.err != nil {
+		return e.err
+	}
+	return bw.Flush()
+}
+
+// Decode reads a Bytecode written by Encode.
+func Decode(r io.Reader) (*Bytecode, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	if magic := d.read(len(Magic)); d.err != nil || string(magic) != Magic {
+		if d.err != nil && !errors.Is(d.err, io.EOF) && !errors.Is(d.err, io.ErrUnexpectedEOF) {
+			return nil, d.err
+		}
+		return nil, ErrNotBytecode
+	}
+	if version := d.uint16(); d.err == nil && version != FormatVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d (want %d)", version, FormatVersion)
+	}
+	b := &Bytecode{}
+	b.Instructions = d.bytes()
+	b.Positions = d.positions()
+	n := d.uint32()
+	for i := 0; i < n && d.err == nil; i++ {
+		b.Constants = append(b.Constants, d.constant())
+	}
+	if d.err != nil {
+		if errors.Is(d.err, io.EOF) {
+			d.err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("corrupt bytecode: %w", d.err)
+	}
+	return b, nil
+}
+
+// encoder writes the format, keeping the first error.
+type encoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *encoder) write(data []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(data)
+	}
+}
+
+func (e *encoder) uint16(v uint16) {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, v)
+	e.write(buf)
+}
+
+func (e *encoder) uint32(v int) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(v))
+	e.write(buf)
+}
+
+func (e *encoder) uint64(v uint64) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	e.write(buf)
+}
+
+func (e *encoder) bytes(data []byte) {
+	e.uint32(len(data))
+	e.write(data)
+}
+
+func (e *encoder) positions(t code.PositionTable) {
+	e.uint32(len(t))
+	for _, entry := range t {
+		e.uint32(entry.Offset)
+		e.bytes([]byte(entry.Pos.Filename))
+		e.uint32(entry.Pos.Line)
+		e.uint32(entry.Pos.Column)
+	}
+}
+
+func (e *encoder) constant(obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.write([]byte{tagInteger})
+		e.uint64(uint64(obj.Value))
+	case *object.Float:
+		e.write([]byte{tagFloat})
+		e.uint64(math.Float64bits(obj.Value))
+	case *object.String:
+		e.write([]byte{tagString})
+		e.bytes([]byte(obj.Value))
+	case *object.CompiledFunction:
+		e.write([]byte{tagFunction})
+		e.bytes([]byte(obj.Name))
+		e.uint32(obj.NumLocals)
+		e.uint32(obj.NumParameters)
+		e.bytes(obj.Instructions)
+		e.positions(obj.Positions)
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("cannot encode constant of type %s", obj.Type())
+		}
+	}
+}
+
+// decoder reads the format, keeping the first error. Once it has failed,
+// every read returns a zero value.
+type decoder struct {
+	r   io.Reader
+	err error
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	// read through a limit rather than into a buffer of n bytes, so that a
+	// corrupt length cannot allocate more than the input holds
+	buf, err := io.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err == nil && len(buf) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		d.err = err
+		return nil
+	}
+	return buf
+}
+
+func (d *decoder) byte() byte {
+	if buf := d.read(1); buf != nil {
+		return buf[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if buf := d.read(2); buf != nil {
+		return binary.BigEndian.Uint16(buf)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() int {
+	if buf := d.read(4); buf != nil {
+		return int(binary.BigEndian.Uint32(buf))
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if buf := d.read(8); buf != nil {
+		return binary.BigEndian.Uint64(buf)
+	}
+	return 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if d.err != nil {
+		return nil
+	}
+	return d.read(n)
+}
+
+func (d *decoder) positions() code.PositionTable {
+	n := d.uint32()
+	var t code.PositionTable
+	for i := 0; i < n && d.err == nil; i++ {
+		entry := code.PositionEntry{Offset: d.uint32()}
+		entry.Pos = token.Position{
+			Filename: string(d.bytes()),
+			Line:     d.uint32(),
+			Column:   d.uint32(),
+		}
+		t = append(t, entry)
+	}
+	return t
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: int64(d.uint64())}
+	case tagFloat:
+		return &object.Float{Value: math.Float64frombits(d.uint64())}
+	case tagString:
+		return &object.String{Value: string(d.bytes())}
+	case tagFunction:
+		fn := &object.CompiledFunction{Name: string(d.bytes())}
+		fn.NumLocals = d.uint32()
+		fn.NumParameters = d.uint32()
+		fn.Instructions = d.bytes()
+		fn.Positions = d.positions()
+		return fn
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown constant tag %d", tag)
+		}
+		return nil
+	}
+}
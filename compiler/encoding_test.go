@@ -0,0 +1,88 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"monkey/object"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	input := `
+let pi = 3.14;
+let greet = fn(name) { "hello " + name };
+let adder = fn(a) { fn(b) { a + b } };
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+puts(greet("monkey"), adder(-1)(2), fib(10), pi);
+`
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	var buf bytes.Buffer
+	if err := bytecode.Encode(&buf); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(Magic)) {
+		t.Fatalf("encoding does not start with %q", Magic)
+	}
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	if !bytes.Equal(decoded.Instructions, bytecode.Instructions) {
+		t.Errorf("wrong instructions.\nwant=%q\ngot =%q", bytecode.Instructions, decoded.Instructions)
+	}
+	if !reflect.DeepEqual(decoded.Positions, bytecode.Positions) {
+		t.Errorf("wrong positions.\nwant=%v\ngot =%v", bytecode.Positions, decoded.Positions)
+	}
+	if !reflect.DeepEqual(decoded.Constants, bytecode.Constants) {
+		t.Errorf("wrong constants.\nwant=%#v\ngot =%#v", bytecode.Constants, decoded.Constants)
+	}
+}
+
+func TestEncodeUnsupportedConstant(t *testing.T) {
+	bytecode := &Bytecode{Constants: []object.Object{&object.Boolean{Value: true}}}
+	err := bytecode.Encode(io.Discard)
+	if err == nil || err.Error() != "cannot encode constant of type BOOLEAN" {
+		t.Fatalf("wrong error. got=%v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var valid bytes.Buffer
+	bytecode := &Bytecode{Constants: []object.Object{&object.String{Value: "monkey"}}}
+	if err := bytecode.Encode(&valid); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	data := valid.Bytes()
+
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{nil, ErrNotBytecode.Error()},
+		{[]byte("#!/usr/bin/env monkey"), ErrNotBytecode.Error()},
+		{append([]byte(Magic), 0, 99), "unsupported bytecode version 99 (want 2)"},
+		{data[:len(data)-2], "corrupt bytecode: unexpected EOF"},
+		{append(append([]byte{}, data[:len(data)-11]...), 42), "corrupt bytecode: unknown constant tag 42"},
+	}
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.input))
+		if err == nil {
+			t.Errorf("expected error decoding %q, got none", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+	if _, err := Decode(bytes.NewReader(nil)); !errors.Is(err, ErrNotBytecode) {
+		t.Errorf("expected ErrNotBytecode, got %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package compiler
+
+import (
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+)
+
+var (
+	constTrue  = &object.Boolean{Value: true}
+	constFalse = &object.Boolean{Value: false}
+)
+
+// foldConstant evaluates an expression built only from literals and
+// operators, with the semantics of the VM. It reports false for anything
+// else, including operations that would fail at run time, so that their
+// error is still raised by the VM.
+func foldConstant(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.Boolean:
+		return foldBool(node.Value), true
+	case *ast.PrefixExpression:
+		right, ok := foldConstant(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldPrefix(node.Operator, right)
+	case *ast.InfixExpression:
+		left, ok := foldConstant(node.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := foldConstant(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(node.Operator, left, right)
+	}
+	return nil, false
+}
+
+func foldPrefix(op string, right object.Object) (object.Object, bool) {
+	switch op {
+	case "!":
+		if b, ok := right.(*object.Boolean); ok {
+			return foldBool(!b.Value), true
+		}
+		return constFalse, true
+	case "-":
+		switch right := right.(type) {
+		case *object.Integer:
+			return &object.Integer{Value: -right.Value}, true
+		case *object.Float:
+			return &object.Float{Value: -right.Value}, true
+		}
+	}
+	return nil, false
+}
+
+func foldInfix(op string, left object.Object, right object.Object) (object.Object, bool) {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		l := left.(*object.Integer).Value
+		r := right.(*object.Integer).Value
+		switch op {
+		case "+":
+			return &object.Integer{Value: l + r}, true
+		case "-":
+			return &object.Integer{Value: l - r}, true
+		case "*":
+			return &object.Integer{Value: l * r}, true
+		case "/":
+			if r == 0 {
+				return nil, false
+			}
+			return &object.Integer{Value: l / r}, true
+		case "<":
+			return foldBool(l < r), true
+		case ">":
+			return foldBool(l > r), true
+		case "==":
+			return foldBool(l == r), true
+		case "!=":
+			return foldBool(l != r), true
+		}
+	case isNumber(left) && isNumber(right):
+		l, _ := object.ToFloat(left)
+		r, _ := object.ToFloat(right)
+		switch op {
+		case "+":
+			return &object.Float{Value: l + r}, true
+		case "-":
+			return &object.Float{Value: l - r}, true
+		case "*":
+			return &object.Float{Value: l * r}, true
+		case "/":
+			return &object.Float{Value: l / r}, true
+		case "<":
+			return foldBool(l < r), true
+		case ">":
+			return foldBool(l > r), true
+		case "==":
+			return foldBool(l == r), true
+		case "!=":
+			return foldBool(l != r), true
+		}
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		l := left.(*object.String).Value
+		r := right.(*object.String).Value
+		switch op {
+		case "+":
+			return &object.String{Value: l + r}, true
+		case "==":
+			return foldBool(l == r), true
+		case "!=":
+			return foldBool(l != r), true
+		}
+	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
+		l := left.(*object.Boolean).Value
+		r := right.(*object.Boolean).Value
+		switch op {
+		case "==":
+			return foldBool(l == r), true
+		case "!=":
+			return foldBool(l != r), true
+		}
+	}
+	return nil, false
+}
+
+func foldBool(value bool) *object.Boolean {
+	if value {
+		return constTrue
+	}
+	return constFalse
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// isTruthy mirrors the VM's truthiness for folded conditions.
+func isTruthy(obj object.Object) bool {
+	if b, ok := obj.(*object.Boolean); ok {
+		return b.Value
+	}
+	return true
+}
+
+// emitFolded emits the instruction that pushes a folded constant.
+func (c *Compiler) emitFolded(obj object.Object) {
+	if b, ok := obj.(*object.Boolean); ok {
+		if b.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+		return
+	}
+	c.emit(code.OpConstant, c.addConstant(obj))
+}
+
+// removeJumpsToNext drops every OpJump whose target is the instruction right
+// after it.
+func removeJumpsToNext(ins code.Instructions, positions code.PositionTable) (code.Instructions, code.PositionTable) {
+	for {
+		var changed bool
+		ins, positions, changed = rewriteInstructions(ins, positions, func(ins code.Instructions, offset int) (code.Instructions, bool) {
+			if code.Opcode(ins[offset]) == code.OpJump && int(code.ReadUint16(ins[offset+1:])) == offset+3 {
+				return code.Instructions{}, true
+			}
+			return nil, false
+		})
+		// removing a jump can make the one before it jump to the next
+		// instruction too
+		if !changed {
+			return ins, positions
+		}
+	}
+}
+
+// rewriteInstructions replaces every instruction for which replace reports
+// true by the instructions it returns, which must not be jumps. The targets
+// of the remaining jumps and the position table entries are moved to the new
+// offsets; a jump to a replaced instruction lands on its replacement.
+func rewriteInstructions(
+	ins code.Instructions,
+	positions code.PositionTable,
+	replace func(ins code.Instructions, offset int) (code.Instructions, bool),
+) (code.Instructions, code.PositionTable, bool) {
+	// newOffsets maps the offset of every instruction, and the end of the
+	// instructions, to its offset after the rewrite
+	newOffsets := make(map[int]int)
+	replacements := make(map[int]code.Instructions)
+	length := 0
+	for i := 0; i < len(ins); i += instructionWidth(ins, i) {
+		newOffsets[i] = length
+		if r, ok := replace(ins, i); ok {
+			replacements[i] = r
+			length += len(r)
+		} else {
+			length += instructionWidth(ins, i)
+		}
+	}
+	newOffsets[len(ins)] = length
+	if len(replacements) == 0 {
+		return ins, positions, false
+	}
+
+	rewritten := make(code.Instructions, 0, length)
+	for i := 0; i < len(ins); i += instructionWidth(ins, i) {
+		op := code.Opcode(ins[i])
+		if r, ok := replacements[i]; ok {
+			rewritten = append(rewritten, r...)
+		} else if op == code.OpJump || op == code.OpJumpNotTruthy {
+			rewritten = append(rewritten, code.Make(op, newOffsets[int(code.ReadUint16(ins[i+1:]))])...)
+		} else {
+			rewritten = append(rewritten, ins[i:i+instructionWidth(ins, i)]...)
+		}
+	}
+
+	var table code.PositionTable
+	for _, e := range positions {
+		e.Offset = newOffsets[e.Offset]
+		// a removed instruction's entry lands on the next instruction's
+		// offset, whose own entry supersedes it
+		if n := len(table); n > 0 && table[n-1].Offset == e.Offset {
+			table[n-1] = e
+			continue
+		}
+		table = append(table, e)
+	}
+	return rewritten, table, true
+}
+
+func instructionWidth(ins code.Instructions, offset int) int {
+	def, err := code.Lookup(ins[offset])
+	if err != nil {
+		return 1
+	}
+	width := 1
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	return width
+}
@@ -0,0 +1,187 @@
+package compiler
+
+import (
+	"monkey/code"
+	"monkey/token"
+	"reflect"
+	"testing"
+)
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-1; -2.5; 1.5 * 2; 7 / 2",
+			expectedConstants: []interface{}{-1, -2.5, 3.0, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key"`,
+			expectedConstants: []interface{}{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `!true; 1 < 2; "a" == "b"; true != false; !5`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// operations failing at run time are left to the VM
+			input:             `1 / 0; -"a"`,
+			expectedConstants: []interface{}{1, 0, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; x + 2 * 3",
+			expectedConstants: []interface{}{1, 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	for i := range tests {
+		tests[i].optimize = true
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestConstantConditionPruning(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (1 < 2) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 }; if (false) { 10 } else { 20 };",
+			expectedConstants: []interface{}{20},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// an empty branch must not take the previous statement's pop
+			input:             "1; if (true) { }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { if (true) { let a = 1; } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpNull),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	for i := range tests {
+		tests[i].optimize = true
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestRemoveJumpsToNext(t *testing.T) {
+	pos := func(line int) token.Position { return token.Position{Line: line, Column: 1} }
+	ins := concateInstructions([]code.Instructions{
+		code.Make(code.OpTrue),              // 0000
+		code.Make(code.OpJumpNotTruthy, 13), // 0001
+		code.Make(code.OpJump, 7),           // 0004: to the next instruction
+		code.Make(code.OpConstant, 0),       // 0007
+		code.Make(code.OpJump, 14),          // 0010
+		code.Make(code.OpNull),              // 0013
+		code.Make(code.OpJump, 17),          // 0014: to the end
+	})
+	positions := code.PositionTable{
+		{Offset: 0, Pos: pos(1)},
+		{Offset: 4, Pos: pos(2)},
+		{Offset: 7, Pos: pos(3)},
+		{Offset: 13, Pos: pos(4)},
+		{Offset: 14, Pos: pos(5)},
+	}
+
+	expected := concateInstructions([]code.Instructions{
+		code.Make(code.OpTrue),              // 0000
+		code.Make(code.OpJumpNotTruthy, 10), // 0001
+		code.Make(code.OpConstant, 0),       // 0004
+		code.Make(code.OpJump, 11),          // 0007
+		code.Make(code.OpNull),              // 0010
+	})
+	expectedPositions := code.PositionTable{
+		{Offset: 0, Pos: pos(1)},
+		{Offset: 4, Pos: pos(3)},
+		{Offset: 10, Pos: pos(4)},
+		{Offset: 11, Pos: pos(5)},
+	}
+
+	actual, actualPositions := removeJumpsToNext(ins, positions)
+	if actual.String() != expected.String() {
+		t.Errorf("wrong instructions.\nwant=%q\ngot =%q", expected, actual)
+	}
+	if !reflect.DeepEqual(actualPositions, expectedPositions) {
+		t.Errorf("wrong positions.\nwant=%v\ngot =%v", expectedPositions, actualPositions)
+	}
+}
@@ -1,12 +1,15 @@
 package compiler
 
+import "monkey/object"
+
 type SymbolScope string
 
 const (
-	LocalScope   SymbolScope = "LOCAL"
-	GlobalScope  SymbolScope = "GLOBAL"
-	BuiltinScope SymbolScope = "BUILTIN"
-	FreeScope    SymbolScope = "FREE"
+	LocalScope    SymbolScope = "LOCAL"
+	GlobalScope   SymbolScope = "GLOBAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -28,12 +31,24 @@ func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{store: s, numDefinitions: 0, FreeSymbols: free}
 }
 
+// NewSymbolTableWithBuiltins returns a global symbol table defining every
+// builtin of a registry.
+func NewSymbolTableWithBuiltins(builtins *object.BuiltinRegistry) *SymbolTable {
+	s := NewSymbolTable()
+	for i := 0; i < builtins.Len(); i++ {
+		s.DefineBuiltin(i, builtins.At(i).Name)
+	}
+	return s
+}
+
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	s := NewSymbolTable()
 	s.Outer = outer
 	return s
 }
 
+// Define binds name in this table. Redefining a name already bound here
+// reuses its slot, so `let x = x + 1` updates x like it does in the evaluator.
 func (s *SymbolTable) Define(name string) Symbol {
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	if s.Outer == nil {
@@ -41,6 +56,9 @@ func (s *SymbolTable) Define(name string) Symbol {
 	} else {
 		symbol.Scope = LocalScope
 	}
+	if existing, ok := s.store[name]; ok && existing.Scope == symbol.Scope {
+		return existing
+	}
 	s.store[name] = symbol
 	s.numDefinitions++
 	return symbol
@@ -68,6 +86,14 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+// DefineFunctionName defines the name of the function being compiled in its
+// own scope, so that the function can refer to itself.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
@@ -292,3 +292,55 @@ func TestResolveUnresolvableFree(t *testing.T) {
 	}
 
 }
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
+
+func TestShadowingFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+	global.Define("a")
+
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
+
+func TestRedefineReusesSlot(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	global.Define("b")
+	again := global.Define("a")
+	if again != a {
+		t.Errorf("redefined a got a new slot. want=%+v, got=%+v", a, again)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	local.Resolve("a") // a is global, not shadowed
+	shadow := local.Define("a")
+	expected := Symbol{Name: "a", Scope: LocalScope, Index: 0}
+	if shadow != expected {
+		t.Errorf("local definition wrong. want=%+v, got=%+v", expected, shadow)
+	}
+	if local.numDefinitions != 1 {
+		t.Errorf("wrong number of local definitions. want=1, got=%d", local.numDefinitions)
+	}
+}
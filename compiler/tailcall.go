@@ -0,0 +1,30 @@
+package compiler
+
+import "monkey/code"
+
+// markTailCalls turns every call whose result the function returns at once
+// into an OpTailCall, which reuses the frame of the function for the callee.
+// A jump to an OpReturnValue is first replaced by the return itself, so
+// that calls at the end of if branches are followed by their return.
+func markTailCalls(ins code.Instructions, positions code.PositionTable) (code.Instructions, code.PositionTable) {
+	ins, positions, _ = rewriteInstructions(ins, positions, func(ins code.Instructions, offset int) (code.Instructions, bool) {
+		if code.Opcode(ins[offset]) != code.OpJump {
+			return nil, false
+		}
+		target := int(code.ReadUint16(ins[offset+1:]))
+		if target < len(ins) && code.Opcode(ins[target]) == code.OpReturnValue {
+			return code.Make(code.OpReturnValue), true
+		}
+		return nil, false
+	})
+
+	ins = append(code.Instructions{}, ins...)
+	for i := 0; i < len(ins); i += instructionWidth(ins, i) {
+		next := i + instructionWidth(ins, i)
+		if code.Opcode(ins[i]) == code.OpCall && next < len(ins) && code.Opcode(ins[next]) == code.OpReturnValue {
+			// the return stays, for calls of builtins
+			ins[i] = byte(code.OpTailCall)
+		}
+	}
+	return ins, positions
+}
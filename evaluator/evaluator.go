@@ -1,18 +1,60 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
 	"monkey/ast"
 	"monkey/object"
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := eval(node, env)
+	if errObj, ok := result.(*object.Error); ok && !errObj.Pos.IsValid() {
+		// the innermost node that produced the error gives its position
+		errObj.Pos = node.Pos()
+	}
+	return result
+}
+
+// EvalContext is like Eval, but stops once ctx is done, evaluating to an
+// error with the message of ctx's error. The context is checked on every
+// function call and loop iteration.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	prev := env.SetContext(ctx)
+	defer env.SetContext(prev)
+	return Eval(node, env)
+}
+
+// allocated accounts a newly created value to the memory quota of env. It
+// returns an error in place of the value once the quota is exceeded.
+func allocated(env *object.Environment, obj object.Object) object.Object {
+	if err := env.MemoryQuota().Account(obj); err != nil {
+		return &object.Error{Message: err.Error(), Err: err}
+	}
+	return obj
+}
+
+// interrupted returns an error if the context of env is done.
+func interrupted(env *object.Environment) *object.Error {
+	ctx := env.Context()
+	if ctx == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return &object.Error{Message: ctx.Err().Error(), Err: ctx.Err()}
+	default:
+		return nil
+	}
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
@@ -21,42 +63,58 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForInStatement:
+		return evalForInStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocated(env, &object.Array{Elements: elements})
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		hash := evalHashLiteral(node, env)
+		if isAbrupt(hash) {
+			return hash
+		}
+		return allocated(env, hash)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return allocated(env, evalInfixExpression(node.Operator, left, right))
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.Identifier:
@@ -64,27 +122,16 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return &object.Function{Name: node.Name, Parameters: params, Body: body, Env: env}
 	case *ast.CallExpression:
-		if node.Function.TokenLiteral() == "quote" {
-			return quote(node.Arguments[0], env)
-		}
-		function := Eval(node.Function, env)
-		if isError(function) {
-			return function
-		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
-		}
-		return applyFunction(function, args)
+		return evalCallExpression(node, env, false)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
@@ -101,24 +148,179 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 		case *object.ReturnValue:
 			return result.Value
 		case *object.Error:
+			traceFrame(result, object.MainFunctionName)
 			return result
 		}
 	}
 	return result
 }
 
+// traceFrame completes the innermost unfinished frame of err's stack trace
+// with the name of the function it ran in. A frame's position is known
+// before its function is: it is where the error was raised, or the call
+// that raised it, which leaves an unnamed frame for that position.
+func traceFrame(err *object.Error, function string) {
+	if len(err.Trace) == 0 {
+		err.Trace = append(err.Trace, object.TraceFrame{Pos: err.Pos})
+	}
+	err.Trace[len(err.Trace)-1].Function = function
+}
+
+func evalCallExpression(node *ast.CallExpression, env *object.Environment, tail bool) object.Object {
+	if node.Function.TokenLiteral() == "quote" {
+		return quote(node.Arguments[0], env)
+	}
+	function := Eval(node.Function, env)
+	if isAbrupt(function) {
+		return function
+	}
+	args := evalExpressions(node.Arguments, env)
+	if len(args) == 1 && isAbrupt(args[0]) {
+		return args[0]
+	}
+	if fn, ok := function.(*object.Function); ok && tail {
+		return &object.TailCall{Fn: fn, Args: args, Pos: node.Pos()}
+	}
+	result := applyFunction(function, args)
+	if _, ok := function.(*object.Builtin); ok {
+		if err := env.MemoryQuota().AccountResult(result, args); err != nil {
+			return &object.Error{Message: err.Error(), Err: err}
+		}
+	}
+	if errObj, ok := result.(*object.Error); ok && len(errObj.Trace) > 0 {
+		// the caller's frame left by applyFunction is at this call
+		if caller := &errObj.Trace[len(errObj.Trace)-1]; !caller.Pos.IsValid() {
+			caller.Pos = node.Pos()
+		}
+	}
+	return result
+}
+
+// evalTail evaluates a node in tail position of a function body, where a
+// call of a function is returned as an *object.TailCall for applyFunction
+// to make in place of the current call.
+func evalTail(node ast.Node, env *object.Environment) object.Object {
+	result := evalTailNode(node, env)
+	if errObj, ok := result.(*object.Error); ok && !errObj.Pos.IsValid() {
+		errObj.Pos = node.Pos()
+	}
+	return result
+}
+
+func evalTailNode(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		var result object.Object
+		for i, statement := range node.Statements {
+			if i == len(node.Statements)-1 {
+				return evalTail(statement, env)
+			}
+			result = Eval(statement, env)
+			if result != nil {
+				rt := result.Type()
+				if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ || rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
+					return result
+				}
+			}
+		}
+		return result
+	case *ast.ExpressionStatement:
+		return evalTail(node.Expression, env)
+	case *ast.ReturnStatement:
+		val := evalTail(node.ReturnValue, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+	case *ast.IfExpression:
+		condition := Eval(node.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if isTruely(condition) {
+			return evalTail(node.Consequence, env)
+		} else if node.Alternative != nil {
+			return evalTail(node.Alternative, env)
+		}
+		return NULL
+	case *ast.CallExpression:
+		return evalCallExpression(node, env, true)
+	}
+	return Eval(node, env)
+}
+
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	stmts := block.Statements
 	var result object.Object
 	for _, statement := range stmts {
 		result = Eval(statement, env)
-		if result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ {
-			return result
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ || rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
+				return result
+			}
 		}
 	}
 	return result
 }
 
+func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		if err := interrupted(env); err != nil {
+			return err
+		}
+		condition := Eval(node.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruely(condition) {
+			return NULL
+		}
+		result := Eval(node.Body, env)
+		if stop, value := loopControl(result); stop {
+			return value
+		}
+	}
+}
+
+func evalForInStatement(node *ast.ForInStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	it, ok := object.NewIterator(iterable)
+	if !ok {
+		return newError("not iterable: %s", iterable.Type())
+	}
+	for element, ok := it.Next(); ok; element, ok = it.Next() {
+		if err := interrupted(env); err != nil {
+			return err
+		}
+		env.Set(node.Variable.Value, element)
+		result := Eval(node.Body, env)
+		if stop, value := loopControl(result); stop {
+			return value
+		}
+	}
+	return NULL
+}
+
+// loopControl interprets the result of a loop body: stop reports whether the
+// loop ends, and value is what the loop statement then evaluates to.
+func loopControl(result object.Object) (stop bool, value object.Object) {
+	if result == nil {
+		return false, nil
+	}
+	switch result.Type() {
+	case object.BREAK_OBJ:
+		return true, NULL
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return true, result
+	default:
+		return false, nil
+	}
+}
+
 func evalPrefixExpression(op string, expr object.Object) object.Object {
 	switch op {
 	case "!":
@@ -134,12 +336,14 @@ func evalInfixExpression(op string, left object.Object, right object.Object) obj
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(op, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(op, left, right)
 	case op == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case op == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), op, right.Type())
@@ -151,7 +355,7 @@ func evalInfixExpression(op string, left object.Object, right object.Object) obj
 
 func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(node.Condition, env)
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
 	if isTruely(condition) {
@@ -163,11 +367,69 @@ func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Ob
 	}
 }
 
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if _, ok := env.Assign(target.Value, val); !ok {
+			return newError("identifier not found: " + target.Value)
+		}
+		return val
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return evalIndexAssignment(left, index, val, env)
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+}
+
+func evalIndexAssignment(left object.Object, index object.Object, val object.Object, env *object.Environment) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		arrayObject := left.(*object.Array)
+		idx := index.(*object.Integer).Value
+		if idx < 0 || idx >= int64(len(arrayObject.Elements)) {
+			return newError("index out of range: %d (len %d)", idx, len(arrayObject.Elements))
+		}
+		arrayObject.Elements[idx] = val
+		return val
+	case left.Type() == object.HASH_OBJ:
+		hashObject := left.(*object.Hash)
+		hashKey, ok := object.HashKeyOf(index)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		if _, ok := hashObject.Pairs[hashKey]; !ok {
+			if err := env.MemoryQuota().AccountPair(); err != nil {
+				return &object.Error{Message: err.Error(), Err: err}
+			}
+		}
+		hashObject.Set(hashKey, object.HashPair{Key: index, Value: val})
+		return val
+	default:
+		return newError("index assignment not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin := env.Builtins().Lookup(node.Value); builtin != nil {
 		return builtin
 	}
 
@@ -179,7 +441,7 @@ func evalExpressions(exprs []ast.Expression, env *object.Environment) []object.O
 
 	for _, e := range exprs {
 		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -192,6 +454,10 @@ func evalIndexExpression(left object.Object, index object.Object) object.Object
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.TUPLE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalTupleIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -211,14 +477,33 @@ func evalArrayIndexExpression(left object.Object, index object.Object) object.Ob
 	}
 }
 
+func evalTupleIndexExpression(left object.Object, index object.Object) object.Object {
+	tuple := left.(*object.Tuple)
+	idx := index.(*object.Integer).Value
+	if idx < 0 || idx >= int64(len(tuple.Elements)) {
+		return NULL
+	}
+	return tuple.Elements[idx]
+}
+
+// evalStringIndexExpression returns the character at an index of a string.
+func evalStringIndexExpression(left object.Object, index object.Object) object.Object {
+	str := left.(*object.String)
+	char, ok := object.CharAt(str.Value, index.(*object.Integer).Value)
+	if !ok {
+		return NULL
+	}
+	return &object.String{Value: char}
+}
+
 func evalHashIndexExpression(left object.Object, index object.Object) object.Object {
 	hashObject := left.(*object.Hash)
-	key, ok := index.(object.Hashable)
+	key, ok := object.HashKeyOf(index)
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Pairs[key]
 	if !ok {
 		return NULL
 	} else {
@@ -227,36 +512,56 @@ func evalHashIndexExpression(left object.Object, index object.Object) object.Obj
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash(len(node.Keys))
 
-	for kn, vn := range node.Pairs {
+	for _, kn := range node.Keys {
 		k := Eval(kn, env)
-		if isError(k) {
+		if isAbrupt(k) {
 			return k
 		}
-		hk, ok := k.(object.Hashable)
+		hk, ok := object.HashKeyOf(k)
 		if !ok {
 			return newError("unusable as hash key: %s", k.Type())
 		}
-		v := Eval(vn, env)
-		if isError(v) {
+		v := Eval(node.Pairs[kn], env)
+		if isAbrupt(v) {
 			return v
 		}
-		hashed := hk.HashKey()
-		pairs[hashed] = object.HashPair{Key: k, Value: v}
+		hash.Set(hk, object.HashPair{Key: k, Value: v})
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendedFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if err := checkCall(fn, args); err != nil {
+			return err
+		}
+		// a tail call of the body is made here, in place of the call of fn,
+		// so that tail recursion runs in constant stack
+		for {
+			extendedEnv := extendedFunctionEnv(fn, args)
+			evaluated := unwrapReturnValue(evalTail(fn.Body, extendedEnv))
+			tailCall, ok := evaluated.(*object.TailCall)
+			if ok {
+				if err := checkCall(tailCall.Fn, tailCall.Args); err != nil {
+					err.Pos = tailCall.Pos
+					evaluated, ok = err, false
+				}
+			}
+			if !ok {
+				if errObj, isErr := evaluated.(*object.Error); isErr {
+					traceFrame(errObj, fn.Name)
+					errObj.Trace = append(errObj.Trace, object.TraceFrame{})
+				}
+				return evaluated
+			}
+			fn, args = tailCall.Fn, tailCall.Args
+		}
 	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
+		if result := fn.Call(caller{}, args...); result != nil {
 			return result
 		} else {
 			return NULL
@@ -266,6 +571,22 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
+// caller applies the functions given to builtins.
+type caller struct{}
+
+func (caller) CallFunction(fn object.Object, args ...object.Object) object.Object {
+	return applyFunction(fn, args)
+}
+
+// checkCall returns the error of calling fn with args before its body runs,
+// if any.
+func checkCall(fn *object.Function, args []object.Object) *object.Error {
+	if len(args) != len(fn.Parameters) {
+		return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+	}
+	return interrupted(fn.Env)
+}
+
 func extendedFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
 	env := object.NewEnclosedEnvironment(fn.Env)
 	for i, param := range fn.Parameters {
@@ -282,9 +603,11 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = object.NULL
+	TRUE     = object.TRUE
+	FALSE    = object.FALSE
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func nativeBoolToBooleanObject(value bool) object.Object {
@@ -309,11 +632,14 @@ func evalBangOperatorExpression(expr object.Object) object.Object {
 }
 
 func evalMinusOperatorExpression(expr object.Object) object.Object {
-	if expr.Type() != object.INTEGER_OBJ {
+	switch expr := expr.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -expr.Value}
+	case *object.Float:
+		return &object.Float{Value: -expr.Value}
+	default:
 		return newError("unknown operator: -%s", expr.Type())
 	}
-	value := expr.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalIntegerInfixExpression(op string, left object.Object, right object.Object) object.Object {
@@ -327,6 +653,9 @@ func evalIntegerInfixExpression(op string, left object.Object, right object.Obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero: %d / %d", leftVal, rightVal)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
@@ -341,17 +670,52 @@ func evalIntegerInfixExpression(op string, left object.Object, right object.Obje
 	}
 }
 
+// evalFloatInfixExpression evaluates arithmetic and comparisons between two
+// numbers of which at least one is a float.
+func evalFloatInfixExpression(op string, left object.Object, right object.Object) object.Object {
+	leftVal, _ := object.ToFloat(left)
+	rightVal, _ := object.ToFloat(right)
+	switch op {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
+	}
+}
+
 func evalStringInfixExpression(op string, left object.Object, right object.Object) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
 	switch op {
 	case "+":
 		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
 	}
 }
 
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
 func isTruely(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -369,9 +733,17 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func isError(obj object.Object) bool {
-	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+// isAbrupt reports whether obj ends the evaluation of the expressions
+// around it: an error, or the signal of a return, break or continue
+// statement.
+func isAbrupt(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	switch obj.Type() {
+	case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	default:
+		return false
 	}
-	return false
 }
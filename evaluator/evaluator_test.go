@@ -1,10 +1,14 @@
 package evaluator
 
 import (
+	"context"
+	"fmt"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"runtime/debug"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -34,6 +38,45 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1e3", 1000},
+		{"2.5e-1", 0.25},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"10 - 0.25", 9.75},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestEvalFloatComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1.0 == 1", true},
+		{"1 != 1.0", false},
+		{"0.1 + 0.2 == 0.3", false},
+		{"{1: true}[1.0]", true},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -53,6 +96,10 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
+		{"[1, [2]] == [1, [2]]", true},
+		{`{"a": 1} != {"a": 1}`, false},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -167,6 +214,10 @@ func TestErrorHandling(t *testing.T) {
 			"foobar",
 			"identifier not found: foobar",
 		},
+		{
+			"10 / (5 - 5)",
+			"division by zero: 10 / 0",
+		},
 		{
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
@@ -191,6 +242,149 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "1:3"},
+		{"let x = 1;\n  -true", "2:3"},
+		{"let f = fn() {\n  foobar;\n};\nf();", "2:3"},
+		{"len(1, 2)", "1:4"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Pos.String() != tt.expected {
+			t.Errorf("wrong error position. expected=%q, got=%q", tt.expected, errObj.Pos)
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 + true;", []string{"at <main> (1:3)"}},
+		{
+			"let inner = fn(x) {\n  x / 0\n};\nlet outer = fn(y) {\n  inner(y + 1) + 1\n};\nouter(1);",
+			[]string{"at inner (2:5)", "at outer (5:8)", "at <main> (7:6)"},
+		},
+		{
+			// a tail call replaces the frame of its caller
+			"let inner = fn(x) {\n  x / 0\n};\nlet outer = fn(y) {\n  inner(y + 1)\n};\nouter(1);",
+			[]string{"at inner (2:5)", "at <main> (7:6)"},
+		},
+		{
+			"let f = fn(a) { a };\nlet g = fn() { f(1, 2) };\ng();",
+			[]string{"at g (2:17)", "at <main> (3:2)"},
+		},
+		{
+			"fn() { -true }();",
+			[]string{"at <anonymous> (1:8)", "at <main> (1:15)"},
+		},
+		{
+			"let fact = fn(n) { if (n == 0) { 1 / 0 } else { n * fact(n - 1) } };\nfact(2);",
+			[]string{"at fact (1:36)", "at fact (1:57)", "at fact (1:57)", "at <main> (2:5)"},
+		},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+		}
+		if len(errObj.Trace) != len(tt.expected) {
+			t.Fatalf("wrong trace length for %q. want=%d, got=%d:\n%s",
+				tt.input, len(tt.expected), len(errObj.Trace), errObj.StackTrace())
+		}
+		for i, frame := range errObj.Trace {
+			if frame.String() != tt.expected[i] {
+				t.Errorf("wrong frame %d. want=%q, got=%q", i, tt.expected[i], frame.String())
+			}
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let i = 0; let sum = 0; while (i < 5) { let sum = sum + i; let i = i + 1; } sum;`, 10},
+		{`let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; } sum;`, 6},
+		{`let n = 0; for (k in {"a": 1, "b": 2}) { let n = n + 1; } n;`, 2},
+		{`let s = ""; for (c in "abc") { let s = c + s; } s;`, "cba"},
+		{`let i = 0; while (true) { if (i > 3) { break; } let i = i + 1; } i;`, 4},
+		{`let sum = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let sum = sum + x; } sum;`, 8},
+		{`let f = fn(arr) { for (x in arr) { if (x > 1) { return x; } } -1 }; f([0, 5, 7]);`, 5},
+		{`let f = fn(arr) { for (x in arr) { if (x > 9) { return x; } } -1 }; f([0, 5, 7]);`, -1},
+		{`for (x in 5) { x }`, "not iterable: INTEGER"},
+		{`let i = 0; while (i < 3) { let i = i + 1; }; i;`, 3},
+		{`let s = ""; for (c in "héllo") { let s = s + c + "."; } s;`, "h.é.l.l.o."},
+		{`let s = ""; for (k in {"b": 1, "a": 2, "c": 3}) { let s = s + k; } s;`, "bac"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("object is not String %q. got=%T(%+v)", expected, evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 1; a = 2; a;", 2},
+		{"let a = 1; a = a + 2;", 3},
+		{"let a = 1; let b = 2; a = b = 5; a + b;", 10},
+		{"let a = 1; let f = fn() { a = 10; }; f(); a;", 10},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let c = counter(); c(); c(); c();", 3},
+		{"let i = 0; let sum = 0; while (i < 5) { sum = sum + i; i = i + 1; } sum;", 10},
+		{"let arr = [1, 2, 3]; arr[1] = 20; arr[1];", 20},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"];`, 3},
+		{"b = 1;", "identifier not found: b"},
+		{"let arr = [1]; arr[1] = 2;", "index out of range: 1 (len 1)"},
+		{`let h = {}; h[fn(x) { x }] = 1;`, "unusable as hash key: FUNCTION"},
+		{`let s = "ab"; s[0] = "c";`, "index assignment not supported: STRING[INTEGER]"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -243,6 +437,102 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestTailCalls(t *testing.T) {
+	// tail calls run in the frame of their caller, so deep tail recursion
+	// fits in a small Go stack
+	defer debug.SetMaxStack(debug.SetMaxStack(16 << 20))
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(100000, 0);", 5000050000},
+		{"let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(100000, 0);", 5000050000},
+		{`
+		let odd = fn(n, even) { if (n == 0) { false } else { even(n - 1, odd) } };
+		let even = fn(n, odd) { if (n == 0) { true } else { odd(n - 1, even) } };
+		even(10001, odd);`, false},
+		{"let f = fn(arr) { len(arr) }; f([1, 2, 3]);", 3},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelExpired()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		expected string
+	}{
+		{"while (true) {}", canceled, "context canceled"},
+		{"for (x in [1, 2]) { while (true) {} }", expired, "context deadline exceeded"},
+		{"let f = fn() { f() }; f();", expired, "context deadline exceeded"},
+		{"let f = fn() { 1 + f() }; f();", canceled, "context canceled"},
+	}
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		evaluated := EvalContext(tt.ctx, testParseProgram(tt.input), env)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, errObj.Message)
+		}
+		if env.Context() != nil {
+			t.Errorf("context of the environment not restored")
+		}
+	}
+}
+
+func TestMemoryQuota(t *testing.T) {
+	tests := []struct {
+		input    string
+		limit    int64
+		exceeded bool
+	}{
+		{"let a = []; while (true) { a = push(a, 1) }", 1 << 20, true},
+		{`let s = "x"; while (true) { s = s + s }`, 1 << 20, true},
+		{"let h = {}; let i = 0; while (true) { h[i] = i; i = i + 1 }", 1 << 20, true},
+		{"while (true) { {1: [1, 2, 3]} }", 1 << 20, true},
+		{"let a = [1, 2, 3]; push(a, 4)", 1 << 10, false},
+		{`let a = ["abc"]; let i = 0; while (i < 10000) { first(a); last(a); i = i + 1 }`, 1 << 10, false},
+		{"let h = {1: 2}; let i = 0; while (i < 10000) { h[1] = i; i = i + 1 }", 1 << 10, false},
+	}
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetMemoryQuota(object.NewMemoryQuota(tt.limit))
+		ctx, cancel := context.WithTimeout(context.Background(), testEvalTimeout)
+		evaluated := EvalContext(ctx, testParseProgram(tt.input), env)
+		cancel()
+
+		errObj, isErr := evaluated.(*object.Error)
+		if !tt.exceeded {
+			if isErr {
+				t.Errorf("unexpected error for %q: %s", tt.input, errObj.Message)
+			}
+			continue
+		}
+		expected := fmt.Sprintf("memory limit exceeded: allocated more than %d bytes", tt.limit)
+		if !isErr || errObj.Message != expected {
+			t.Errorf("wrong result for %q. want error %q, got=%s", tt.input, expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestClosures(t *testing.T) {
 	input := `
 	let newAdder = fn(x) {
@@ -308,6 +598,134 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // inspected result
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })", "[2, 4, 6]"},
+		{"let n = 10; map([1, 2], fn(x) { x + n })", "[11, 12]"},
+		{"map([[1], [1, 2]], len)", "[1, 2]"},
+		{"filter([1, 2, 3, 4], fn(x) { x > 2 })", "[3, 4]"},
+		{"filter([1, 2], fn(x) { if (x > 1) { 0 } })", "[2]"},
+		{"reduce([1, 2, 3], 10, fn(acc, x) { acc + x })", "16"},
+		{"let sum = 0; each([1, 2, 3], fn(x) { sum = sum + x }); sum", "6"},
+		{"each([1], fn(x) { x })", "null"},
+		{"any([1, 2], fn(x) { x > 1 })", "true"},
+		{"all([], fn(x) { false })", "true"},
+		{"let calls = 0; all([1, 2, 3], fn(x) { calls = calls + 1; x < 2 }); calls", "2"},
+		{"sort_by([3, 1, 2], fn(x) { -x })", "[3, 2, 1]"},
+		{`sort_by(["bb", "a", "c"], fn(s) { s })`, `[a, bb, c]`},
+		{"let f = fn(a) { return map(a, fn(x) { return x + 1; }); }; f([1])", "[2]"},
+		{"map(1, len)", "ERROR: 1:4: argument to `map` must be ARRAY, got INTEGER"},
+		{"filter([1], 1)", "ERROR: 1:7: argument to `filter` must be a function, got INTEGER"},
+		{"map([1], fn(a, b) { a })", "ERROR: 1:4: wrong number of arguments: want=2, got=1"},
+		{"map([1], fn(x) { x / 0 }); 1", "ERROR: 1:20: division by zero: 1 / 0"},
+		{`sort_by([1, 2], fn(x) { if (x > 1) { "a" } else { x } })`, "ERROR: 1:8: `sort_by` keys cannot be compared: INTEGER and STRING"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // inspected result
+	}{
+		{`"abc"[1]`, "b"},
+		{`let s = "abc"; s[len(s) - 1]`, "c"},
+		{`split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`join(split("a b c", " "), "-")`, "a-b-c"},
+		{`trim("  a b  ")`, "a b"},
+		{`[contains("monkey", "key"), starts_with("monkey", "mon"), ends_with("monkey", "mon")]`, "[true, true, false]"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`upper("Monkey") + lower("Monkey")`, "MONKEYmonkey"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`[index_of("monkey", "key"), index_of("monkey", "ape")]`, "[3, -1]"},
+		{`[substring("monkey", 1, 3), substring("monkey", -5, 99), substring("monkey", 4, 2)]`, "[on, monkey, ]"},
+		{`[ord("a"), ord("é")]`, "[97, 233]"},
+		{`chr(97) + chr(233)`, "aé"},
+		{`split(1, ",")`, "ERROR: 1:6: argument to `split` must be STRING, got INTEGER"},
+		{`repeat("a", -1)`, "ERROR: 1:7: negative `repeat` count: -1"},
+		{`chr(55296)`, "ERROR: 1:4: invalid character code: 55296"},
+		{`"abc"["a"]`, "ERROR: 1:6: index operator not supported: STRING[STRING]"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // inspected result
+	}{
+		{`{"b": 1, "a": 2, "c": 3}`, "{b:1, a:2, c:3}"},
+		{`let h = {"b": 1}; h["a"] = 2; h["b"] = 3; h`, "{b:3, a:2}"},
+		{`[keys({3: 1, 1: 2}), values({3: 1, 1: 2})]`, "[[3, 1], [1, 2]]"},
+		{`[has({"a": if (false) { 1 }}, "a"), has({"a": if (false) { 1 }}, "b")]`, "[true, false]"},
+		{`let h = {1: 1, 2: 2, 3: 3}; [delete(h, 2), delete(h, 2), h]`, "[true, false, {1:1, 3:3}]"},
+		{`merge({1: 1, 2: 2}, {3: 3, 1: 4})`, "{1:4, 2:2, 3:3}"},
+		{`keys([])`, "ERROR: 1:5: argument to `keys` must be HASH, got ARRAY"},
+		{`delete({}, fn() {})`, "ERROR: 1:7: unusable as hash key: FUNCTION"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestRegisteredBuiltins(t *testing.T) {
+	builtins := object.NewBuiltinRegistry()
+	_, err := builtins.Register("double", 1, func(args ...object.Object) object.Object {
+		return &object.Integer{Value: 2 * args[0].(*object.Integer).Value}
+	})
+	if err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"double(21)", 42},
+		{"let f = fn(x) { double(x) + len([x]) }; f(4)", 9},
+		{"double(1, 2)", "wrong number of arguments. got=2, want=1"},
+		{"let double = fn(x) { x }; double(3)", 3},
+	}
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetBuiltins(builtins)
+		evaluated := Eval(testParseProgram(tt.input), env)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+
+	if _, ok := testEval("double(1)").(*object.Error); !ok {
+		t.Errorf("registered builtin visible without its registry")
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	evaluated := testEval(input)
@@ -339,6 +757,7 @@ func TestArrayIndexExpressions(t *testing.T) {
 		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
 		{"[1, 2, 3][3]", nil},
 		{"[1, 2, 3][-1]", nil},
+		{`"abc"[3]`, nil},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -367,6 +786,88 @@ func TestStringHashKey(t *testing.T) {
 	}
 }
 
+func TestSelfReferencingValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // inspected result
+	}{
+		{`let a = [1]; a[0] = a; a`, "[[...]]"},
+		{`let a = [1, 2]; a[1] = [a]; a`, "[1, [[...]]]"},
+		{`let h = {"a": 1}; h["self"] = h; h`, "{a:1, self:{...}}"},
+		{`let a = [1]; a[0] = a; tuple(a)`, "(((...),),)"},
+		{`let a = [1]; let b = [a, a]; b`, "[[1], [1]]"},
+		{`let a = [1]; a[0] = a; let h = {}; h[a] = 1`, "ERROR: 1:41: unusable as hash key: ARRAY"},
+		{`let a = [1]; a[0] = a; has({}, tuple(a))`, "ERROR: 1:27: unusable as hash key: TUPLE"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	keyOf := func(obj object.Object) object.HashKey {
+		key, ok := object.HashKeyOf(obj)
+		if !ok {
+			t.Fatalf("%s has no hash key", obj.Inspect())
+		}
+		return key
+	}
+	str := func(s string) object.Object { return &object.String{Value: s} }
+	array := func(elements ...object.Object) object.Object { return &object.Array{Elements: elements} }
+
+	same := [][2]object.Object{
+		{array(str("a"), str("b")), object.NewTuple([]object.Object{str("a"), str("b")})},
+		{array(&object.Integer{Value: 1}), array(&object.Float{Value: 1})},
+		{array(array()), object.NewTuple([]object.Object{object.NewTuple(nil)})},
+	}
+	for _, tt := range same {
+		if keyOf(tt[0]) != keyOf(tt[1]) {
+			t.Errorf("%s and %s have different hash keys", tt[0].Inspect(), tt[1].Inspect())
+		}
+	}
+	different := [][2]object.Object{
+		{array(str("a:b")), array(str("a"), str("b"))},
+		{array(str("ab"), str("")), array(str("a"), str("b"))},
+		{array(str("1")), array(&object.Integer{Value: 1})},
+		{array(array(str("a")), str("b")), array(array(str("a"), str("b")))},
+		{array(), array(array())},
+		{str("1"), &object.Integer{Value: 1}},
+	}
+	for _, tt := range different {
+		if keyOf(tt[0]) == keyOf(tt[1]) {
+			t.Errorf("%s and %s have the same hash key", tt[0].Inspect(), tt[1].Inspect())
+		}
+	}
+	if _, ok := object.HashKeyOf(array(&object.Function{})); ok {
+		t.Errorf("array of a function has a hash key")
+	}
+
+	tests := []struct {
+		input    string
+		expected string // inspected result
+	}{
+		{`let grid = {}; grid[[1, 2]] = 3; grid`, "{(1, 2):3}"},
+		{`{[1]: 1}[tuple(1)]`, "1"},
+		{`let k = [1, [2]]; let h = {k: 1}; k[1][0] = 5; [h, h[[1, [2]]], h[k]]`, "[{(1, (2,)):1}, 1, null]"},
+		{`tuple(1, "a")[1]`, "a"},
+		{`{1: "a", 1.0: "b"}`, "{1:b}"},
+		{`{[1, fn(x) { x }]: 1}`, "ERROR: 1:1: unusable as hash key: ARRAY"},
+		{`{tuple([1, fn() { 1 }]): 1}`, "ERROR: 1:1: unusable as hash key: TUPLE"},
+		{`let h = {}; h[tuple(fn(x) { x })] = 1`, "ERROR: 1:35: unusable as hash key: TUPLE"},
+		{`has({}, tuple({}))`, "ERROR: 1:4: unusable as hash key: TUPLE"},
+		{`let t = tuple(1); t[0] = 2`, "ERROR: 1:24: index assignment not supported: TUPLE[INTEGER]"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestHashLiterals(t *testing.T) {
 	input := `let two = "two";
 	{
@@ -426,12 +927,18 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+// testEvalTimeout bounds every evaluation of the tests, so that a runaway
+// program fails its test instead of hanging the suite.
+const testEvalTimeout = 10 * time.Second
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
 	env := object.NewEnvironment()
-	return Eval(program, env)
+	ctx, cancel := context.WithTimeout(context.Background(), testEvalTimeout)
+	defer cancel()
+	return EvalContext(ctx, program, env)
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
@@ -448,6 +955,20 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float, got=%T(%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value, want=%g, got=%g", expected, result.Value)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -0,0 +1,155 @@
+package interp
+
+import (
+	"reflect"
+	"testing"
+)
+
+// conformanceCase is a script that must give the same result on every
+// backend, with and without the compiler's optimizations.
+type conformanceCase struct {
+	input    string
+	expected interface{} // as exported
+}
+
+func runConformanceTests(t *testing.T, tests []conformanceCase) {
+	t.Helper()
+	for _, backend := range backends {
+		for _, optimize := range []bool{false, true} {
+			if backend != VM && optimize {
+				continue
+			}
+			for _, tt := range tests {
+				r := New(backend)
+				r.Optimize = optimize
+				r.MaxInstructions = 1000000
+				result, err := r.Eval("conformance.monkey", tt.input)
+				if err != nil {
+					t.Errorf("%s (optimize=%t): error for %q: %s", backend, optimize, tt.input, err)
+					continue
+				}
+				if !reflect.DeepEqual(result, tt.expected) {
+					t.Errorf("%s (optimize=%t): wrong result for %q. want=%#v, got=%#v",
+						backend, optimize, tt.input, tt.expected, result)
+				}
+			}
+		}
+	}
+}
+
+func TestEqualityConformance(t *testing.T) {
+	runConformanceTests(t, []conformanceCase{
+		{"1 == 1.0", true},
+		{"1 == 2", false},
+		{`1 == "1"`, false},
+		{"true == 1", false},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] == [2, 1]", false},
+		{"[1] == [1, 2]", false},
+		{"[1] == [1.0]", true},
+		{"[true] == [1]", false},
+		{"[] == []", true},
+		{"[] == {}", false},
+		{"[1, 2] != [1, 2]", false},
+		{"let a = [1]; let b = a; b[0] = 2; a == b", true},
+		{"let a = [1]; let b = [1]; b[0] = 2; a == b", false},
+		{`{"a": 1, "b": 2} == {"b": 2, "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{`{"a": 1} == {"a": 1, "b": 2}`, false},
+		{`{"a": [1, {"b": 2}]} == {"a": [1, {"b": 2}]}`, true},
+		{"{1: true} == {1.0: true}", true},
+		{"{[1, 2]: 3} == {tuple(1, 2): 3}", true},
+		{"tuple(1, 2) == [1, 2]", true},
+		{"tuple(1) == tuple(1, 2)", false},
+		{"let n = if (false) { 1 }; [n == n, n == 0, n == false, n == []]", []interface{}{true, false, false, false}},
+		{"let f = fn(x) { x }; [f == f, f == fn(x) { x }, f != f]", []interface{}{true, false, false}},
+		{"let mk = fn() { fn() { 1 } }; mk() == mk()", false},
+		{"[len == len, len == first]", []interface{}{true, false}},
+		{"let f = fn(x) { x }; [f] == [f]", true},
+		{"let a = [1, 2]; a[0] = a; let b = [1, 2]; b[0] = b; a == b", true},
+		{"let a = [1, 2]; a[0] = a; let b = [1, 3]; b[0] = b; a == b", false},
+		{`let h = {}; h["self"] = h; let g = {}; g["self"] = g; h == g`, true},
+		{"filter([[1], [2], [1]], fn(x) { x == [1] })", []interface{}{
+			[]interface{}{int64(1)}, []interface{}{int64(1)},
+		}},
+	})
+}
+
+func TestEvaluationOrderConformance(t *testing.T) {
+	runConformanceTests(t, []conformanceCase{
+		{"let i = 1; [(i = i + 1) < (i = i * 10), i]", []interface{}{true, int64(20)}},
+		{"let i = 1; [(i = i + 1) > (i = i * 10), i]", []interface{}{false, int64(20)}},
+		{"let i = 1; [(i = i + 1) - (i = i * 10), i]", []interface{}{int64(-18), int64(20)}},
+		{"let i = 1; [(i = i + 1) < 2.5, i]", []interface{}{true, int64(2)}},
+		{"1 < 2", true},
+		{"2 < 1", false},
+		{"1.5 < 2", true},
+	})
+}
+
+func TestLoopControlConformance(t *testing.T) {
+	runConformanceTests(t, []conformanceCase{
+		{"let i = 0; let n = 0; while (i < 5000) { i = i + 1; let y = 1 + if (true) { continue; } else { 2 }; n = n + 1 }; [i, n]",
+			[]interface{}{int64(5000), int64(0)}},
+		{"let i = 0; while (i < 5000) { i = i + 1; [1, 2, if (i == 4000) { break; } else { 3 }] }; i", int64(4000)},
+		{"let i = 0; while (i < 5000) { i = i + 1; let g = fn(a, b) { a }; g(1, if (true) { continue; }) }; i", int64(5000)},
+		{`let s = 0; for (x in [1, 2, 3]) { s = s + {"a": if (x == 2) { continue; } else { x }}["a"] }; s`, int64(4)},
+		{"let a = [0]; let i = 0; while (i < 10) { i = i + 1; a[0] = if (i > 3) { break; } else { i } }; a", []interface{}{int64(3)}},
+		{"let n = 0; for (x in [1, 2]) { for (y in [1, 2, 3]) { n = n + 10 * if (y == 2) { break; } else { y } } }; n", int64(20)},
+		{"let i = 0; while (i < 3) { i = i + 1; let y = if (true) { continue; }; }; i", int64(3)},
+		{"let f = fn() { let i = 0; while (true) { i = i + 1; if (i == 3) { return 1 + if (true) { return i } } } }; f()", int64(3)},
+		{"let f = fn() { [1, if (true) { return 2 }] }; f()", int64(2)},
+	})
+}
+
+func TestSelfReferenceConformance(t *testing.T) {
+	runConformanceTests(t, []conformanceCase{
+		{`let f = fn(n) { if (n == 0) { "orig" } else { f(n - 1) } }; let g = f; f = fn(n) { "new" }; g(1)`, "new"},
+		{"let f = fn() { f = 1; 2 }; [f(), f]", []interface{}{int64(2), int64(1)}},
+		{"let w = fn() { let f = fn() { f = 1; 2 }; [f(), f] }; w()", []interface{}{int64(2), int64(1)}},
+		{"let w = fn() { let f = fn() { let g = fn() { f = 5 }; g(); 1 }; [f(), f] }; w()", []interface{}{int64(1), int64(5)}},
+		{"let w = fn() { let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(5) }; w()", int64(120)},
+		{"let f = fn() { 1 }; let f = fn() { f }; f() == f", true},
+	})
+}
+
+func TestResultConformance(t *testing.T) {
+	runConformanceTests(t, []conformanceCase{
+		{"1; while (false) {}", nil},
+		{"1; for (x in []) {}", nil},
+		{"1; let x = 5;", nil},
+		{"let x = 5; x", int64(5)},
+		{"let x = 5; x;", int64(5)},
+		{"", nil},
+		{"// only a comment", nil},
+		{"let i = 0; while (i < 3) { i = i + 1 }; i", int64(3)},
+	})
+}
+
+func TestHashKeyConformance(t *testing.T) {
+	runConformanceTests(t, []conformanceCase{
+		{`{1: "a", 1.0: "b"}`, map[interface{}]interface{}{int64(1): "b"}},
+		{`keys({1: "a", 1.0: "b"})`, []interface{}{int64(1)}},
+		{`let h = {1.0: "a"}; h[1] = "b"; [keys(h), values(h)]`, []interface{}{[]interface{}{1.0}, []interface{}{"b"}}},
+		{`keys(merge({1: 1}, {1.0: 2, 2: 3}))`, []interface{}{int64(1), int64(2)}},
+		{`let h = {[1]: 1}; h[tuple(1)] = 2; keys(h)[0] == [1]`, true},
+	})
+}
+
+func TestStringCharacterConformance(t *testing.T) {
+	runConformanceTests(t, []conformanceCase{
+		{`len("héllo")`, int64(5)},
+		{`len("日本")`, int64(2)},
+		{`["héllo"[1], "héllo"[4], "héllo"[5], "日本"[-1]]`, []interface{}{"é", "o", nil, nil}},
+		{`substring("héllo", 1, 3)`, "él"},
+		{`substring("日本語", 1, 99)`, "本語"},
+		{`[index_of("héllo", "l"), index_of("日本語", "語"), index_of("héllo", "x")]`, []interface{}{int64(2), int64(2), int64(-1)}},
+		{`let cs = []; for (c in "héllo") { cs = push(cs, c) }; cs`, []interface{}{"h", "é", "l", "l", "o"}},
+		{`let s = "日本語"; let i = 0; let n = 0; while (i < len(s)) { n = n + ord(s[i]); i = i + 1 }; n`, int64(26085 + 26412 + 35486)},
+		{`ord("é")`, int64(233)},
+	})
+}
@@ -0,0 +1,476 @@
+package interp
+
+import (
+	"fmt"
+	"math"
+	"monkey/object"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// maxConvertDepth bounds the nesting of converted values, which stops the
+// conversion of cyclic data.
+const maxConvertDepth = 1000
+
+var (
+	objectType         = reflect.TypeOf((*object.Object)(nil)).Elem()
+	emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// ToObject returns the Monkey value of a Go value:
+//
+//	nil, nil pointers, maps and slices      null
+//	bool                                    boolean
+//	signed and unsigned integers            integer
+//	float32, float64                        float
+//	string                                  string
+//	slices and arrays                       array
+//	maps with keys converting to hash keys  hash
+//	structs                                 hash with a string key per field
+//
+// Pointers and interfaces are converted to the value they hold, and
+// object.Object values are returned as they are. A struct field is keyed by
+// its name, or by the name in its `monkey:"name"` tag; the tag "-" skips the
+// field, and the option omitempty skips it if it holds a zero value.
+// Unexported fields are skipped.
+func ToObject(v interface{}) (object.Object, error) {
+	return toObject(reflect.ValueOf(v), "", 0)
+}
+
+func toObject(v reflect.Value, path string, depth int) (object.Object, error) {
+	if depth > maxConvertDepth {
+		return nil, convertError(path, "nested more than %d levels deep", maxConvertDepth)
+	}
+	if !v.IsValid() {
+		return object.NULL, nil
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return object.NULL, nil
+	}
+	if obj, ok := v.Interface().(object.Object); ok {
+		return obj, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return object.TRUE, nil
+		}
+		return object.FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, convertError(path, "%d overflows a Monkey integer", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Ptr, reflect.Interface:
+		return toObject(v.Elem(), path, depth+1)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return object.NULL, nil
+		}
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			elem, err := toObject(v.Index(i), fmt.Sprintf("%s[%d]", path, i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = elem
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return object.NULL, nil
+		}
+		hash := object.NewHash(v.Len())
+		keys := v.MapKeys()
+		// the pairs are added in a fixed order, since maps have none
+		sort.Slice(keys, func(i, j int) bool {
+			return lessKey(keys[i], keys[j])
+		})
+		for _, k := range keys {
+			keyPath := fmt.Sprintf("%s[%v]", path, k)
+			key, err := toObject(k, keyPath, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			hashKey, ok := object.HashKeyOf(key)
+			if !ok {
+				return nil, convertError(keyPath, "unusable as hash key: %s", key.Type())
+			}
+			value, err := toObject(v.MapIndex(k), keyPath, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(hashKey, object.HashPair{Key: key, Value: value})
+		}
+		return hash, nil
+	case reflect.Struct:
+		hash := object.NewHash(v.NumField())
+		for _, f := range structFields(v.Type()) {
+			field := v.Field(f.index)
+			if f.omitEmpty && field.IsZero() {
+				continue
+			}
+			value, err := toObject(field, path+"."+f.name, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			key := &object.String{Value: f.name}
+			hash.Set(key.HashKey(), object.HashPair{Key: key, Value: value})
+		}
+		return hash, nil
+	default:
+		return nil, convertError(path, "cannot convert %s to a Monkey value", v.Type())
+	}
+}
+
+// lessKey orders map keys: numbers by value before strings by their
+// contents, before other keys by their formatting.
+func lessKey(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface && !a.IsNil() && !b.IsNil() {
+		a, b = a.Elem(), b.Elem()
+	}
+	if keyRank(a) != keyRank(b) {
+		return keyRank(a) < keyRank(b)
+	}
+	switch {
+	case isInt(a) && isInt(b):
+		return a.Int() < b.Int()
+	case isUint(a) && isUint(b):
+		return a.Uint() < b.Uint()
+	case isNumeric(a):
+		return toFloat(a) < toFloat(b)
+	case a.Kind() == reflect.String:
+		return a.String() < b.String()
+	default:
+		return fmt.Sprint(a) < fmt.Sprint(b)
+	}
+}
+
+func keyRank(v reflect.Value) int {
+	switch {
+	case isNumeric(v):
+		return 0
+	case v.Kind() == reflect.String:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUint(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func isNumeric(v reflect.Value) bool {
+	return isInt(v) || isUint(v) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch {
+	case isInt(v):
+		return float64(v.Int())
+	case isUint(v):
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
+
+// FromObject stores the Go value of a Monkey value in the value target
+// points to, converting it to the target's type: the reverse of ToObject.
+// Integers convert to any numeric type they fit in and floats to float
+// types. Null stores the zero value. Hash keys that match no field of a
+// struct are ignored. Into an interface{}, the value is stored as by
+// Export, and into a type implementing object.Object, as it is.
+func FromObject(obj object.Object, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("interp: FromObject needs a non-nil pointer, got %T", target)
+	}
+	return fromObject(obj, v.Elem(), "", 0)
+}
+
+func fromObject(obj object.Object, v reflect.Value, path string, depth int) error {
+	if depth > maxConvertDepth {
+		return convertError(path, "nested more than %d levels deep", maxConvertDepth)
+	}
+	if obj == nil {
+		obj = object.NULL
+	}
+	t := v.Type()
+	if t.Implements(objectType) && reflect.TypeOf(obj).AssignableTo(t) {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+	if obj.Type() == object.NULL_OBJ {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+	if t.Implements(objectType) {
+		return mismatch(obj, t, path)
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return mismatch(obj, t, path)
+		}
+		exported, err := export(obj, path, depth)
+		if err != nil {
+			return err
+		}
+		if exported != nil {
+			v.Set(reflect.ValueOf(exported))
+		}
+		return nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return fromObject(obj, v.Elem(), path, depth+1)
+	case reflect.Bool:
+		b, ok := obj.(*object.Boolean)
+		if !ok {
+			return mismatch(obj, t, path)
+		}
+		v.SetBool(b.Value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return mismatch(obj, t, path)
+		}
+		if v.OverflowInt(i.Value) {
+			return convertError(path, "%d overflows %s", i.Value, t)
+		}
+		v.SetInt(i.Value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return mismatch(obj, t, path)
+		}
+		if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+			return convertError(path, "%d overflows %s", i.Value, t)
+		}
+		v.SetUint(uint64(i.Value))
+	case reflect.Float32, reflect.Float64:
+		f, ok := object.ToFloat(obj)
+		if !ok {
+			return mismatch(obj, t, path)
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		s, ok := obj.(*object.String)
+		if !ok {
+			return mismatch(obj, t, path)
+		}
+		v.SetString(s.Value)
+	case reflect.Slice, reflect.Array:
+		var elements []object.Object
+		switch obj := obj.(type) {
+		case *object.Array:
+			elements = obj.Elements
+		case *object.Tuple:
+			elements = obj.Elements
+		default:
+			return mismatch(obj, t, path)
+		}
+		if t.Kind() == reflect.Array && t.Len() != len(elements) {
+			return convertError(path, "cannot convert an array of %d elements to %s", len(elements), t)
+		}
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, len(elements), len(elements)))
+		}
+		for i, e := range elements {
+			if err := fromObject(e, v.Index(i), fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return mismatch(obj, t, path)
+		}
+		m := reflect.MakeMapWithSize(t, len(hash.Pairs))
+		for _, pair := range hash.Pairs {
+			keyPath := fmt.Sprintf("%s[%s]", path, pair.Key.Inspect())
+			key := reflect.New(t.Key()).Elem()
+			if err := fromObject(pair.Key, key, keyPath, depth+1); err != nil {
+				return err
+			}
+			value := reflect.New(t.Elem()).Elem()
+			if err := fromObject(pair.Value, value, keyPath, depth+1); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return mismatch(obj, t, path)
+		}
+		fields := structFields(t)
+		for _, pair := range hash.Pairs {
+			name, ok := pair.Key.(*object.String)
+			if !ok {
+				continue
+			}
+			f, ok := lookupField(fields, name.Value)
+			if !ok {
+				continue
+			}
+			if err := fromObject(pair.Value, v.Field(f.index), path+"."+f.name, depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return convertError(path, "cannot convert to %s", t)
+	}
+	return nil
+}
+
+// field is a struct field converted to and from a hash pair.
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+func structFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		tag := f.Tag.Get("monkey")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, opts = tag[:comma], tag[comma+1:]
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, field{name: name, index: i, omitEmpty: opts == "omitempty"})
+	}
+	return fields
+}
+
+// lookupField returns the field keyed by name, preferring an exact match to
+// one ignoring case.
+func lookupField(fields []field, name string) (field, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+func mismatch(obj object.Object, t reflect.Type, path string) error {
+	return convertError(path, "cannot convert %s to %s", obj.Type(), t)
+}
+
+func convertError(path string, format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if path != "" {
+		msg = fmt.Sprintf("%s: %s", strings.TrimPrefix(path, "."), msg)
+	}
+	return fmt.Errorf("interp: %s", msg)
+}
+
+// Export returns the Go value of a Monkey value: int64, float64, string,
+// bool, nil for null, []interface{} for an array, [n]interface{} for a tuple
+// of n elements and map[interface{}]interface{} for a hash, with its elements
+// exported too. Other values, like functions,
+// are returned as they are. Values nested too deeply, like those holding
+// themselves, cannot be exported.
+func Export(obj object.Object) (interface{}, error) {
+	return export(obj, "", 0)
+}
+
+func export(obj object.Object, path string, depth int) (interface{}, error) {
+	if depth > maxConvertDepth {
+		return nil, convertError(path, "nested more than %d levels deep", maxConvertDepth)
+	}
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.Float:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, e := range obj.Elements {
+			exported, err := export(e, fmt.Sprintf("%s[%d]", path, i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = exported
+		}
+		return elements, nil
+	case *object.Tuple:
+		// an array, unlike a slice, can be a key of an exported hash
+		tuple := reflect.New(reflect.ArrayOf(len(obj.Elements), emptyInterfaceType)).Elem()
+		for i, e := range obj.Elements {
+			exported, err := export(e, fmt.Sprintf("%s[%d]", path, i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if exported != nil {
+				tuple.Index(i).Set(reflect.ValueOf(exported))
+			}
+		}
+		return tuple.Interface(), nil
+	case *object.Hash:
+		m := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			keyPath := fmt.Sprintf("%s[%s]", path, pair.Key.Inspect())
+			key, err := export(pair.Key, keyPath, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			value, err := export(pair.Value, keyPath, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	default:
+		return obj, nil
+	}
+}
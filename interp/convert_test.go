@@ -0,0 +1,245 @@
+package interp
+
+import (
+	"context"
+	"math"
+	"monkey/object"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X, Y int
+}
+
+type shape struct {
+	Name    string            `monkey:"name"`
+	Points  []point           `monkey:"points"`
+	Tags    map[string]string `monkey:"tags,omitempty"`
+	Scale   *float64          `monkey:"scale"`
+	Hidden  string            `monkey:"-"`
+	private int
+}
+
+type node struct {
+	Next *node
+}
+
+func TestToObject(t *testing.T) {
+	scale := 1.5
+	var nilMap map[string]int
+	tests := []struct {
+		input    interface{}
+		expected interface{} // as exported
+	}{
+		{nil, nil},
+		{true, true},
+		{int8(-3), int64(-3)},
+		{uint32(7), int64(7)},
+		{float32(0.5), 0.5},
+		{"monkey", "monkey"},
+		{[]int{1, 2}, []interface{}{int64(1), int64(2)}},
+		{[2]bool{true, false}, []interface{}{true, false}},
+		{[]string(nil), nil},
+		{nilMap, nil},
+		{(*int)(nil), nil},
+		{&scale, 1.5},
+		{map[int]string{1: "one"}, map[interface{}]interface{}{int64(1): "one"}},
+		{map[[2]int]bool{{1, 2}: true}, map[interface{}]interface{}{[2]interface{}{int64(1), int64(2)}: true}},
+		{[]interface{}{1, "a", nil}, []interface{}{int64(1), "a", nil}},
+		{
+			shape{Name: "line", Points: []point{{1, 2}, {3, 4}}, Scale: &scale, Hidden: "x", private: 1},
+			map[interface{}]interface{}{
+				"name": "line",
+				"points": []interface{}{
+					map[interface{}]interface{}{"X": int64(1), "Y": int64(2)},
+					map[interface{}]interface{}{"X": int64(3), "Y": int64(4)},
+				},
+				"scale": 1.5,
+			},
+		},
+		{
+			&shape{Tags: map[string]string{"a": "b"}},
+			map[interface{}]interface{}{
+				"name":   "",
+				"points": nil,
+				"tags":   map[interface{}]interface{}{"a": "b"},
+				"scale":  nil,
+			},
+		},
+	}
+	for _, tt := range tests {
+		obj, err := ToObject(tt.input)
+		if err != nil {
+			t.Errorf("error converting %#v: %s", tt.input, err)
+			continue
+		}
+		got, err := Export(obj)
+		if err != nil {
+			t.Errorf("error exporting %#v: %s", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong conversion of %#v.\nwant=%#v\ngot =%#v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestToObjectSingletons(t *testing.T) {
+	for _, tt := range []struct {
+		input    interface{}
+		expected object.Object
+	}{
+		{true, object.TRUE},
+		{false, object.FALSE},
+		{nil, object.NULL},
+		{[]int(nil), object.NULL},
+	} {
+		if obj, _ := ToObject(tt.input); obj != tt.expected {
+			t.Errorf("%#v not converted to the shared %s", tt.input, tt.expected.Inspect())
+		}
+	}
+	s := &object.String{Value: "as is"}
+	if obj, _ := ToObject(s); obj != s {
+		t.Errorf("object converted. got=%#v", obj)
+	}
+}
+
+func TestToObjectOrder(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{shape{Name: "a"}, "{name:a, points:null, scale:null}"},
+		{map[string]int{"c": 1, "a": 2, "b": 3}, "{a:2, b:3, c:1}"},
+		{map[int]string{10: "a", 2: "b", -1: "c"}, "{-1:c, 2:b, 10:a}"},
+		{map[uint8]bool{200: true, 30: false}, "{30:false, 200:true}"},
+		{map[float64]int{10.5: 1, 2.5: 2}, "{2.5:2, 10.5:1}"},
+		{map[interface{}]int{"b": 1, 10: 2, true: 3, 9.5: 4, "a": 5}, "{9.5:4, 10:2, a:5, b:1, true:3}"},
+	}
+	for _, tt := range tests {
+		obj, err := ToObject(tt.input)
+		if err != nil {
+			t.Fatalf("error converting %#v: %s", tt.input, err)
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("wrong order. want=%s, got=%s", tt.expected, obj.Inspect())
+		}
+	}
+}
+
+func TestToObjectErrors(t *testing.T) {
+	cycle := &node{}
+	cycle.Next = cycle
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{make(chan int), "interp: cannot convert chan int to a Monkey value"},
+		{[]interface{}{1, func() {}}, "interp: [1]: cannot convert func() to a Monkey value"},
+		{uint64(math.MaxUint64), "interp: 18446744073709551615 overflows a Monkey integer"},
+		{map[point]int{{1, 2}: 1}, "interp: [{1 2}]: unusable as hash key: HASH"},
+		{shape{Points: []point{{}}, Tags: map[string]string{}}, ""},
+		{cycle, "nested more than 1000 levels deep"},
+	}
+	for _, tt := range tests {
+		_, err := ToObject(tt.input)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	r := New(VM)
+	run := func(input string) object.Object {
+		p, err := r.Compile("test.monkey", input)
+		if err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		obj, err := r.RunObject(context.Background(), p)
+		if err != nil {
+			t.Fatalf("run error: %s", err)
+		}
+		return obj
+	}
+
+	var s shape
+	err := FromObject(run(`{"name": "tri", "points": [{"X": 1, "y": 2}], "tags": {"k": "v"}, "scale": 2, "extra": 1}`), &s)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	expected := shape{Name: "tri", Points: []point{{1, 2}}, Tags: map[string]string{"k": "v"}, Scale: new(float64)}
+	*expected.Scale = 2
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("wrong struct.\nwant=%+v\ngot =%+v", expected, s)
+	}
+
+	var counts map[string]uint8
+	if err := FromObject(run(`{"a": 1, "b": 255}`), &counts); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if !reflect.DeepEqual(counts, map[string]uint8{"a": 1, "b": 255}) {
+		t.Errorf("wrong map. got=%v", counts)
+	}
+
+	var pair [2]string
+	if err := FromObject(run(`["a", "b"]`), &pair); err != nil || pair != [2]string{"a", "b"} {
+		t.Errorf("wrong array. got=%v (%v)", pair, err)
+	}
+
+	var anything interface{}
+	if err := FromObject(run(`[1, "x", true]`), &anything); err != nil ||
+		!reflect.DeepEqual(anything, []interface{}{int64(1), "x", true}) {
+		t.Errorf("wrong interface value. got=%#v (%v)", anything, err)
+	}
+
+	var fn object.Object
+	if err := FromObject(run(`fn(x) { x }`), &fn); err != nil || fn == nil {
+		t.Errorf("function not stored as is. got=%#v (%v)", fn, err)
+	}
+
+	ptr := new(int)
+	if err := FromObject(object.NULL, &ptr); err != nil || ptr != nil {
+		t.Errorf("null not stored as nil. got=%v (%v)", ptr, err)
+	}
+}
+
+func TestFromObjectErrors(t *testing.T) {
+	var n int
+	var small int8
+	var u uint
+	var list []int
+	var arr [3]int
+	var stringer interface{ String() string }
+	var anything interface{}
+	cycle := &object.Array{Elements: []object.Object{nil}}
+	cycle.Elements[0] = cycle
+	tests := []struct {
+		obj      object.Object
+		target   interface{}
+		expected string
+	}{
+		{&object.Integer{Value: 1}, n, "interp: FromObject needs a non-nil pointer, got int"},
+		{&object.String{Value: "1"}, &n, "interp: cannot convert STRING to int"},
+		{&object.Integer{Value: 300}, &small, "interp: 300 overflows int8"},
+		{&object.Integer{Value: -1}, &u, "interp: -1 overflows uint"},
+		{&object.Array{Elements: []object.Object{&object.Integer{Value: 1}, object.TRUE}}, &list, "interp: [1]: cannot convert BOOLEAN to int"},
+		{&object.Array{}, &arr, "interp: cannot convert an array of 0 elements to [3]int"},
+		{&object.Float{Value: 1}, &stringer, "interp: cannot convert FLOAT to interface { String() string }"},
+		{&object.Array{Elements: []object.Object{cycle}}, &anything, "interp: [0]" + strings.Repeat("[0]", 1000) + ": nested more than 1000 levels deep"},
+	}
+	for _, tt := range tests {
+		err := FromObject(tt.obj, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Package interp embeds Monkey in Go programs. A Runtime holds the global
+// state of one Monkey instance: it compiles scripts into Programs that can
+// run any number of times, on the VM or on the evaluator, and converts
+// values between Go and Monkey.
+package interp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+	"time"
+)
+
+// Backend selects how a Runtime executes programs.
+type Backend int
+
+const (
+	VM        Backend = iota // compile to bytecode and run on the virtual machine
+	Evaluator                // walk the syntax tree
+)
+
+func (b Backend) String() string {
+	switch b {
+	case VM:
+		return "vm"
+	case Evaluator:
+		return "evaluator"
+	default:
+		return fmt.Sprintf("Backend(%d)", int(b))
+	}
+}
+
+// Runtime is a Monkey instance. Its globals, including those defined by the
+// programs it runs, persist from one run to the next. A Runtime must not be
+// used by several goroutines at once.
+//
+// The budget fields apply to every run; zero means no limit.
+type Runtime struct {
+	// Optimize enables the compiler's optimizations on the VM backend.
+	Optimize bool
+	// MaxInstructions limits the instructions a run executes on the VM
+	// backend; the evaluator has no instructions to count.
+	MaxInstructions int
+	// MaxDepth sets the call depth of the VM backend, vm.MaxFrames if zero.
+	MaxDepth int
+	// MaxStackSize sets the value stack slots of the VM backend,
+	// vm.MaxStackSize if zero.
+	MaxStackSize int
+	// Timeout limits how long a run may take.
+	Timeout time.Duration
+	// MemoryLimit limits the bytes a run allocates for strings, arrays and
+	// hashes.
+	MemoryLimit int64
+
+	backend  Backend
+	macroEnv *object.Environment
+	builtins *object.BuiltinRegistry
+
+	// VM state
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+
+	// evaluator state
+	env *object.Environment
+}
+
+// New returns a Runtime executing programs with backend.
+func New(backend Backend) *Runtime {
+	r := &Runtime{
+		backend:  backend,
+		macroEnv: object.NewEnvironment(),
+		builtins: object.NewBuiltinRegistry(),
+	}
+	switch backend {
+	case VM:
+		r.symbolTable = compiler.NewSymbolTableWithBuiltins(r.builtins)
+		r.globals = make([]object.Object, vm.GlobalSize)
+	case Evaluator:
+		r.env = object.NewEnvironment()
+		r.env.SetBuiltins(r.builtins)
+	default:
+		panic(fmt.Sprintf("interp: unknown backend %d", int(backend)))
+	}
+	return r
+}
+
+// Backend returns the backend r executes programs with.
+func (r *Runtime) Backend() Backend { return r.backend }
+
+// Set binds the global name to the Monkey value of v, as converted by
+// ToObject. Programs compiled
+// afterwards can refer to it, and those compiled before see the new value
+// if they referred to name already.
+func (r *Runtime) Set(name string, v interface{}) error {
+	obj, err := ToObject(v)
+	if err != nil {
+		return fmt.Errorf("global %s: %w", name, err)
+	}
+	switch r.backend {
+	case VM:
+		symbol, ok := r.symbolTable.Resolve(name)
+		if !ok || symbol.Scope != compiler.GlobalScope {
+			symbol = r.symbolTable.Define(name)
+		}
+		r.globals[symbol.Index] = obj
+	case Evaluator:
+		r.env.Set(name, obj)
+	}
+	return nil
+}
+
+// Register adds a builtin to r, called name and taking arity arguments, or
+// any number if arity is object.Variadic. Programs compiled afterwards can
+// call it like the standard builtins; a global of the same name hides it.
+func (r *Runtime) Register(name string, arity int, fn object.BuiltinFunction) error {
+	return r.define(r.builtins.Register(name, arity, fn))
+}
+
+// RegisterWithCaller is like Register, for a builtin calling the Monkey
+// functions it is given through an object.Caller.
+func (r *Runtime) RegisterWithCaller(name string, arity int, fn object.CallerFunction) error {
+	return r.define(r.builtins.RegisterWithCaller(name, arity, fn))
+}
+
+// define makes a registered builtin visible to the programs compiled next.
+func (r *Runtime) define(builtin *object.Builtin, err error) error {
+	if err != nil {
+		return err
+	}
+	if r.backend == VM {
+		if symbol, ok := r.symbolTable.Resolve(builtin.Name); !ok || symbol.Scope != compiler.GlobalScope {
+			r.symbolTable.DefineBuiltin(r.builtins.Len()-1, builtin.Name)
+		}
+	}
+	return nil
+}
+
+// Get returns the Go value of the global name, and whether it is defined.
+// It fails if the value cannot be exported.
+func (r *Runtime) Get(name string) (interface{}, bool, error) {
+	var obj object.Object
+	switch r.backend {
+	case VM:
+		symbol, ok := r.symbolTable.Resolve(name)
+		if !ok || symbol.Scope != compiler.GlobalScope {
+			return nil, false, nil
+		}
+		obj = r.globals[symbol.Index]
+	default:
+		var ok bool
+		obj, ok = r.env.Get(name)
+		if !ok {
+			return nil, false, nil
+		}
+	}
+	v, err := Export(obj)
+	if err != nil {
+		return nil, true, fmt.Errorf("global %s: %w", name, err)
+	}
+	return v, true, nil
+}
+
+// Program is a script compiled by a Runtime, which can run it any number of
+// times.
+type Program struct {
+	Name string
+
+	runtime  *Runtime
+	node     ast.Node
+	bytecode *compiler.Bytecode
+}
+
+// Compile parses a script and, on the VM backend, compiles it. name is the
+// file name used in error positions. The script can refer to the globals
+// set so far and to those defined by programs compiled before it.
+func (r *Runtime) Compile(name string, input string) (*Program, error) {
+	l := lexer.NewWithFilename(name, input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s: parse failed:\n\t%s", name, strings.Join(p.Errors(), "\n\t"))
+	}
+	evaluator.DefineMacros(program, r.macroEnv)
+	node := evaluator.ExpandMacros(program, r.macroEnv)
+
+	prog := &Program{Name: name, runtime: r, node: node}
+	if r.backend == VM {
+		comp := compiler.NewWithState(r.symbolTable, r.constants)
+		comp.Optimize = r.Optimize
+		if err := comp.Compile(node); err != nil {
+			return nil, fmt.Errorf("%s: compilation failed: %w", name, err)
+		}
+		prog.bytecode = comp.Bytecode()
+		r.constants = prog.bytecode.Constants
+	}
+	return prog, nil
+}
+
+// Run runs a program and returns the Go value of its result, the value of
+// its last statement if that is an expression statement, and nil
+// otherwise. A failure at run time is returned as an
+// *Error, and a result that cannot be exported as the error of Export.
+func (r *Runtime) Run(p *Program) (interface{}, error) {
+	return r.RunContext(context.Background(), p)
+}
+
+// RunContext is like Run, but stops once ctx is done, failing with an
+// *Error wrapping the error of ctx.
+func (r *Runtime) RunContext(ctx context.Context, p *Program) (interface{}, error) {
+	obj, err := r.RunObject(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return Export(obj)
+}
+
+// RunObject is like RunContext, but returns the result as a Monkey value,
+// nil if the program does not end with an expression statement.
+func (r *Runtime) RunObject(ctx context.Context, p *Program) (object.Object, error) {
+	if p.runtime != r {
+		return nil, errors.New("interp: program compiled by another runtime")
+	}
+	var result object.Object
+	var err error
+	if r.backend == VM {
+		result, err = r.runVM(ctx, p)
+	} else {
+		result, err = r.runEvaluator(ctx, p)
+	}
+	if err != nil || !endsWithExpression(p.node) {
+		// the VM's last popped value would be left by an earlier statement
+		return nil, err
+	}
+	return result, nil
+}
+
+func endsWithExpression(node ast.Node) bool {
+	program, ok := node.(*ast.Program)
+	if !ok || len(program.Statements) == 0 {
+		return false
+	}
+	_, ok = program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// Eval compiles and runs a script.
+func (r *Runtime) Eval(name string, input string) (interface{}, error) {
+	p, err := r.Compile(name, input)
+	if err != nil {
+		return nil, err
+	}
+	return r.Run(p)
+}
+
+func (r *Runtime) runVM(ctx context.Context, p *Program) (object.Object, error) {
+	machine := vm.NewWithState(p.bytecode, r.globals)
+	machine.SetBuiltins(r.builtins)
+	if r.MaxDepth > 0 {
+		machine.SetMaxDepth(r.MaxDepth)
+	}
+	if r.MaxStackSize > 0 {
+		machine.SetMaxStackSize(r.MaxStackSize)
+	}
+	machine.SetMaxInstructions(r.MaxInstructions)
+	machine.SetTimeout(r.Timeout)
+	machine.SetMemoryLimit(r.MemoryLimit)
+	if err := machine.RunContext(ctx); err != nil {
+		var rtErr *vm.RuntimeError
+		if errors.As(err, &rtErr) {
+			return nil, &Error{Err: rtErr.Err, Trace: rtErr.Trace}
+		}
+		return nil, err
+	}
+	return machine.LastPoppedStackElem(), nil
+}
+
+func (r *Runtime) runEvaluator(ctx context.Context, p *Program) (object.Object, error) {
+	runCtx := ctx
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	var quota *object.MemoryQuota
+	if r.MemoryLimit > 0 {
+		quota = object.NewMemoryQuota(r.MemoryLimit)
+	}
+	prevQuota := r.env.SetMemoryQuota(quota)
+	defer r.env.SetMemoryQuota(prevQuota)
+
+	result := evaluator.EvalContext(runCtx, p.node, r.env)
+	errObj, ok := result.(*object.Error)
+	if !ok {
+		return result, nil
+	}
+	err := errObj.Err
+	switch {
+	case err == nil:
+		err = errors.New(errObj.Message)
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+		// the deadline was the timeout of the run, not one of ctx
+		err = vm.ErrTimeout
+	}
+	return nil, &Error{Err: err, Trace: errObj.Trace}
+}
+
+// Error is the error of a run that failed at run time, on either backend.
+// The errors of budgets are wrapped as on the VM: vm.ErrInstructionLimit,
+// vm.ErrTimeout, object.ErrMemoryLimit, or the error of the run's context.
+type Error struct {
+	Err   error
+	Trace []object.TraceFrame // innermost frame first
+}
+
+func (e *Error) Error() string {
+	if len(e.Trace) > 0 && e.Trace[0].Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", e.Trace[0].Pos, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// StackTrace renders the error's stack trace, one frame per line.
+func (e *Error) StackTrace() string { return object.FormatTrace(e.Trace) }
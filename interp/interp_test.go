@@ -0,0 +1,283 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"monkey/object"
+	"monkey/vm"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var backends = []Backend{VM, Evaluator}
+
+func TestGlobalsAndResults(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"x * cfg[\"n\"] + len(names)", int64(8)},
+		{"x / 4.0", 0.5},
+		{"names", []interface{}{"a", "b"}},
+		{"cfg", map[interface{}]interface{}{"n": int64(3)}},
+		{"[flag, !flag, nothing]", []interface{}{true, false, nil}},
+		{"{1: \"one\", true: [x]}", map[interface{}]interface{}{int64(1): "one", true: []interface{}{int64(2)}}},
+		{"if (nothing) { 1 }", nil},
+	}
+	for _, backend := range backends {
+		for _, tt := range tests {
+			r := New(backend)
+			r.Set("x", 2)
+			r.Set("names", []interface{}{"a", "b"})
+			r.Set("cfg", map[string]interface{}{"n": 3})
+			r.Set("flag", true)
+			r.Set("nothing", nil)
+
+			result, err := r.Eval("test.monkey", tt.input)
+			if err != nil {
+				t.Fatalf("%s: error for %q: %s", backend, tt.input, err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("%s: wrong result for %q. want=%#v, got=%#v", backend, tt.input, tt.expected, result)
+			}
+		}
+	}
+}
+
+func TestSetUnsupportedValue(t *testing.T) {
+	r := New(VM)
+	if err := r.Set("c", make(chan int)); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestProgramRunsManyTimes(t *testing.T) {
+	for _, backend := range backends {
+		r := New(backend)
+		r.Set("counter", 0)
+		r.Set("step", 1)
+		p, err := r.Compile("counter.monkey", "counter = counter + step; counter")
+		if err != nil {
+			t.Fatalf("%s: compile error: %s", backend, err)
+		}
+		for i, expected := range []int64{1, 2, 3} {
+			if i == 2 {
+				// globals set between runs are seen by compiled programs
+				r.Set("step", 10)
+				expected = 12
+			}
+			result, err := r.Run(p)
+			if err != nil {
+				t.Fatalf("%s: run error: %s", backend, err)
+			}
+			if result != expected {
+				t.Errorf("%s: wrong result of run %d. want=%d, got=%v", backend, i, expected, result)
+			}
+		}
+		if counter, ok, err := r.Get("counter"); !ok || err != nil || counter != int64(12) {
+			t.Errorf("%s: wrong counter. got=%v (%t, %v)", backend, counter, ok, err)
+		}
+	}
+}
+
+func TestProgramsShareGlobals(t *testing.T) {
+	for _, backend := range backends {
+		r := New(backend)
+		if _, err := r.Eval("lib.monkey", "let double = fn(x) { x * 2 };"); err != nil {
+			t.Fatalf("%s: error: %s", backend, err)
+		}
+		result, err := r.Eval("main.monkey", "double(21)")
+		if err != nil {
+			t.Fatalf("%s: error: %s", backend, err)
+		}
+		if result != int64(42) {
+			t.Errorf("%s: wrong result. got=%v", backend, result)
+		}
+		if _, ok, _ := r.Get("double"); !ok {
+			t.Errorf("%s: global double not defined", backend)
+		}
+		if _, ok, _ := r.Get("missing"); ok {
+			t.Errorf("%s: global missing defined", backend)
+		}
+	}
+}
+
+func TestProgramOfAnotherRuntime(t *testing.T) {
+	p, err := New(VM).Compile("test.monkey", "1")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	if _, err := New(VM).Run(p); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	double := func(args ...object.Object) object.Object {
+		return &object.Integer{Value: 2 * args[0].(*object.Integer).Value}
+	}
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"double(21)", int64(42)},
+		{"let f = fn(x) { double(x) }; f(4)", int64(8)},
+		{"hidden", "global"},
+	}
+	for _, backend := range backends {
+		r := New(backend)
+		r.Set("hidden", "global")
+		if err := r.Register("double", 1, double); err != nil {
+			t.Fatalf("%s: register error: %s", backend, err)
+		}
+		if err := r.Register("hidden", 0, double); err != nil {
+			t.Fatalf("%s: register error: %s", backend, err)
+		}
+		for _, tt := range tests {
+			result, err := r.Eval("test.monkey", tt.input)
+			if err != nil {
+				t.Fatalf("%s: error for %q: %s", backend, tt.input, err)
+			}
+			if result != tt.expected {
+				t.Errorf("%s: wrong result for %q. want=%v, got=%v", backend, tt.input, tt.expected, result)
+			}
+		}
+
+		if err := r.Register("double", 1, double); err == nil {
+			t.Errorf("%s: registered double twice", backend)
+		}
+		if err := r.Register("len", 1, double); err == nil {
+			t.Errorf("%s: registered a standard builtin again", backend)
+		}
+		// builtins are registered per runtime
+		if _, err := New(backend).Eval("test.monkey", "double(1)"); err == nil {
+			t.Errorf("%s: double registered in a new runtime", backend)
+		}
+	}
+
+	if _, err := object.Builtins.Register("double", 1, double); err == nil {
+		t.Errorf("extended the standard builtins")
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	input := "let f = fn(x) {\n  x / 0\n};\nf(1) + 1;"
+	for _, backend := range backends {
+		r := New(backend)
+		_, err := r.Eval("test.monkey", input)
+		var runErr *Error
+		if !errors.As(err, &runErr) {
+			t.Fatalf("%s: expected *Error. got=%T(%v)", backend, err, err)
+		}
+		if err.Error() != "test.monkey:2:5: division by zero: 1 / 0" {
+			t.Errorf("%s: wrong message. got=%q", backend, err.Error())
+		}
+		expected := "\tat f (test.monkey:2:5)\n\tat <main> (test.monkey:4:2)"
+		if runErr.StackTrace() != expected {
+			t.Errorf("%s: wrong stack trace.\nwant=%q\ngot =%q", backend, expected, runErr.StackTrace())
+		}
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	for _, backend := range backends {
+		r := New(backend)
+		_, err := r.Eval("test.monkey", "let a = [1]; a[0] = a; a")
+		if err == nil || !strings.Contains(err.Error(), "nested more than 1000 levels deep") {
+			t.Errorf("%s: wrong result error. got=%v", backend, err)
+		}
+		if _, ok, err := r.Get("a"); !ok || err == nil || !strings.HasPrefix(err.Error(), "global a: interp: [0][0]") {
+			t.Errorf("%s: wrong global error. got=%v (%t)", backend, err, ok)
+		}
+		if _, err := r.Eval("test.monkey", `let h = {}; h["h"] = h; [h]`); err == nil {
+			t.Errorf("%s: exported a hash holding itself", backend)
+		}
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		backends []Backend
+		setup    func(r *Runtime)
+		ctx      context.Context
+		input    string
+		expected error
+	}{
+		{backends, func(r *Runtime) { r.Timeout = 10 * time.Millisecond }, context.Background(),
+			"while (true) {}", vm.ErrTimeout},
+		{backends, func(r *Runtime) { r.MemoryLimit = 1 << 16 }, context.Background(),
+			"let a = []; while (true) { a = push(a, 1) }", object.ErrMemoryLimit},
+		{backends, func(r *Runtime) {}, canceled,
+			"while (true) {}", context.Canceled},
+		{[]Backend{VM}, func(r *Runtime) { r.MaxInstructions = 1000 }, context.Background(),
+			"while (true) {}", vm.ErrInstructionLimit},
+	}
+	for _, tt := range tests {
+		for _, backend := range tt.backends {
+			r := New(backend)
+			tt.setup(r)
+			p, err := r.Compile("test.monkey", tt.input)
+			if err != nil {
+				t.Fatalf("%s: compile error: %s", backend, err)
+			}
+			_, err = r.RunContext(tt.ctx, p)
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("%s: wrong error for %q. want=%v, got=%v", backend, tt.input, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"map([1, 2], fn(x) { x * 2 })", []interface{}{int64(2), int64(4)}},
+		{"filter([1, 2, 3], fn(x) { x != 2 })", []interface{}{int64(1), int64(3)}},
+		{"reduce([1, 2, 3], 0, fn(acc, x) { acc + x })", int64(6)},
+		{"let n = 0; each([1, 2], fn(x) { n = n + x }); n", int64(3)},
+		{"[any([1], fn(x) { x > 1 }), all([2], fn(x) { x > 1 })]", []interface{}{false, true}},
+		{`sort_by(["b", "a"], fn(s) { s })`, []interface{}{"a", "b"}},
+		{"twice(fn(x) { x + 1 }, 1)", int64(3)},
+		{"twice(first, [[[1]]])", []interface{}{int64(1)}},
+	}
+	twice := func(caller object.Caller, args ...object.Object) object.Object {
+		result := caller.CallFunction(args[0], args[1])
+		if _, ok := result.(*object.Error); ok {
+			return result
+		}
+		return caller.CallFunction(args[0], result)
+	}
+	for _, backend := range backends {
+		r := New(backend)
+		if err := r.RegisterWithCaller("twice", 2, twice); err != nil {
+			t.Fatalf("%s: register error: %s", backend, err)
+		}
+		for _, tt := range tests {
+			result, err := r.Eval("test.monkey", tt.input)
+			if err != nil {
+				t.Fatalf("%s: error for %q: %s", backend, tt.input, err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("%s: wrong result for %q. want=%#v, got=%#v", backend, tt.input, tt.expected, result)
+			}
+		}
+
+		// a failed call fails the run, with the builtin's caller in the trace
+		_, err := r.Eval("fail.monkey", "let f = fn(x) {\n  x / 0\n};\nlet g = fn(a) { map(a, f) };\ng([1]);")
+		var runErr *Error
+		if !errors.As(err, &runErr) {
+			t.Fatalf("%s: expected *Error. got=%T(%v)", backend, err, err)
+		}
+		expected := "\tat f (fail.monkey:2:5)\n\tat g (fail.monkey:4:20)\n\tat <main> (fail.monkey:5:2)"
+		if runErr.StackTrace() != expected {
+			t.Errorf("%s: wrong stack trace.\nwant=%q\ngot =%q", backend, expected, runErr.StackTrace())
+		}
+	}
+}
@@ -2,28 +2,48 @@ package lexer
 
 import (
 	"monkey/token"
+	"strings"
 )
 
 type Lexer struct {
 	input        string
+	filename     string
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
 	ch           byte // current char under examination
+	line         int  // line of current char
+	column       int  // column of current char
+	tokenLine    int  // line of the last token returned
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+// NewWithFilename returns a Lexer whose token positions refer to filename.
+func NewWithFilename(filename string, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
 	return l
 }
 
 // only support ASCII characters
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
 	l.ch = l.peekChar()
 	l.position = l.readPosition
 	l.readPosition += 1
 }
 
+func (l *Lexer) currentPos() token.Position {
+	return token.Position{Filename: l.filename, Line: l.line, Column: l.column}
+}
+
 func (l *Lexer) peekChar() byte {
 	if l.readPosition >= len(l.input) {
 		return 0
@@ -34,7 +54,13 @@ func (l *Lexer) peekChar() byte {
 
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
-	l.skipWhitspace()
+	doc, ok := l.skipWhitespaceAndComments()
+	pos := l.currentPos()
+	l.tokenLine = pos.Line
+	if !ok {
+		return token.Token{Type: token.ILLEGAL, Literal: "/*", Pos: pos}
+	}
+	tok.Doc = doc
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -85,24 +111,35 @@ func (l *Lexer) NextToken() token.Token {
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		literal, ok := l.readString()
+		if ok {
+			tok.Type = token.STRING
+			tok.Literal = literal
+		} else {
+			tok.Type = token.ILLEGAL
+			tok.Literal = "\"" + literal
+		}
 	case 0:
 		tok = token.Token{Literal: "", Type: token.EOF}
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
+			tok.Doc = doc
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos = pos
+			tok.Doc = doc
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
 	}
 	l.readChar()
+	tok.Pos = pos
+	tok.Doc = doc
 	return tok
 }
 
@@ -126,15 +163,43 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer literal, or a floating-point literal with a
+// fraction and/or an exponent such as 3.14, 1e9 or 2.5e-3.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
+	var tokenType token.TokenType = token.INT
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar() // consume '.'
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.readPosition
+		if next < len(l.input) && (l.input[next] == '+' || l.input[next] == '-') {
+			next++
+		}
+		if next < len(l.input) && isDigit(l.input[next]) {
+			tokenType = token.FLOAT
+			for l.readPosition < next {
+				l.readChar() // consume 'e' and the sign
+			}
+			l.readChar()
+			l.readDigits()
+		}
+	}
+	return l.input[position:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
-func (l *Lexer) readString() string {
+// readString reads a string literal. ok is false if the input ends before
+// the closing quote.
+func (l *Lexer) readString() (literal string, ok bool) {
 	position := l.position + 1
 	for {
 		l.readChar()
@@ -142,7 +207,67 @@ func (l *Lexer) readString() string {
 			break
 		}
 	}
-	return l.input[position:l.position]
+	return l.input[position:l.position], l.ch == '"'
+}
+
+// skipWhitespaceAndComments skips whitespace, `// ...` line comments and
+// `/* ... */` block comments. It returns the text of the comments directly
+// above the next token: comments on their own lines with no blank line in
+// between. ok is false if a block comment is not terminated.
+func (l *Lexer) skipWhitespaceAndComments() (doc string, ok bool) {
+	var comments []string
+	lastLine := 0 // line the last collected comment ends on
+	for {
+		l.skipWhitspace()
+		if l.ch != '/' || (l.peekChar() != '/' && l.peekChar() != '*') {
+			break
+		}
+		startLine := l.line
+		var text string
+		if l.peekChar() == '/' {
+			text = l.readLineComment()
+		} else if text, ok = l.readBlockComment(); !ok {
+			return "", false
+		}
+		if startLine == l.tokenLine || (lastLine != 0 && startLine > lastLine+1) {
+			// trailing comment of the previous token, or separated by a blank line
+			comments = nil
+		}
+		if startLine != l.tokenLine {
+			comments = append(comments, text)
+		}
+		lastLine = l.line
+	}
+	if len(comments) == 0 || l.line > lastLine+1 {
+		return "", true
+	}
+	return strings.Join(comments, "\n"), true
+}
+
+func (l *Lexer) readLineComment() string {
+	position := l.position + 2
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	return strings.TrimSpace(l.input[position:l.position])
+}
+
+func (l *Lexer) readBlockComment() (string, bool) {
+	position := l.position + 2
+	l.readChar() // consume '/'
+	for {
+		l.readChar()
+		if l.ch == 0 {
+			return "", false
+		}
+		if l.ch == '*' && l.peekChar() == '/' {
+			break
+		}
+	}
+	text := l.input[position:l.position]
+	l.readChar() // consume '*'
+	l.readChar() // consume '/'
+	return strings.TrimSpace(text), true
 }
 
 func (l *Lexer) skipWhitspace() {
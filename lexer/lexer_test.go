@@ -6,7 +6,7 @@ import (
 )
 
 func TestNextToken(t *testing.T) {
-	input := `=+(){},;-!/*<>`
+	input := `=+(){},;-!/ *<>`
 	tests := []struct {
 		expectedType    token.TokenType
 		expectedLiteral string
@@ -50,7 +50,7 @@ func TestNextToken2(t *testing.T) {
 	};
 	
 	let result = add(five, ten);
-	!-/*5;
+	!-/ *5;
 	5 < 10 > 5;
 	`
 	tests := []struct {
@@ -248,3 +248,152 @@ func TestNextToken4(t *testing.T) {
 	}
 
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x + "foo";`
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENT, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.INT, 1, 9},
+		{token.SEMICOLON, 1, 10},
+		{token.IDENT, 2, 3},
+		{token.PLUS, 2, 5},
+		{token.STRING, 2, 7},
+		{token.SEMICOLON, 2, 12},
+		{token.EOF, 2, 13},
+	}
+	l := NewWithFilename("test.monkey", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q(%q)", i, tt.expectedType, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d", i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+		if tok.Pos.Filename != "test.monkey" {
+			t.Fatalf("tests[%d] - filename wrong. got=%q", i, tok.Pos.Filename)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; // trailing comment
+/* block
+   comment */ let y = x / 2;
+/* unterminated`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.LET, "let"},
+		{token.IDENT, "y"},
+		{token.ASSIGN, "="},
+		{token.IDENT, "x"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.ILLEGAL, "/*"},
+	}
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q(%q)", i, tt.expectedType, tok.Type, tok.Literal)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	input := `// not a doc: separated by a blank line
+
+// adds two numbers.
+// returns their sum.
+let add = 1;
+let sub = 2; // trailing, not a doc
+let mul = 3;
+/* multiplies */
+let div = 4;`
+	expected := []string{
+		"adds two numbers.\nreturns their sum.",
+		"",
+		"",
+		"multiplies",
+	}
+	l := New(input)
+
+	i := 0
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type != token.LET {
+			if tok.Doc != "" {
+				t.Fatalf("unexpected doc on %q: %q", tok.Literal, tok.Doc)
+			}
+			continue
+		}
+		if tok.Doc != expected[i] {
+			t.Fatalf("let[%d] - doc wrong. expected=%q, got=%q", i, expected[i], tok.Doc)
+		}
+		i++
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	l := New(`"foo`)
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL || tok.Literal != `"foo` {
+		t.Fatalf("wrong token. expected=ILLEGAL(%q), got=%s(%q)", `"foo`, tok.Type, tok.Literal)
+	}
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Fatalf("expected EOF, got=%s(%q)", tok.Type, tok.Literal)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 1e9 2.5E-3 7e+2 1.x 2e 3.`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "2.5E-3"},
+		{token.FLOAT, "7e+2"},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "x"},
+		{token.INT, "2"},
+		{token.IDENT, "e"},
+		{token.INT, "3"},
+		{token.ILLEGAL, "."},
+		{token.EOF, ""},
+	}
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q(%q)", i, tt.expectedType, tok.Type, tok.Literal)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
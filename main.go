@@ -3,14 +3,33 @@ package main
 import (
 	"flag"
 	"fmt"
+	"monkey/compiler"
 	"monkey/repl"
+	"monkey/script"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 )
 
+// BytecodeExt is the extension of compiled Monkey programs.
+const BytecodeExt = ".mbc"
+
 func main() {
 	interpreter := flag.Bool("interpreter", false, "use interpreter instead of VM")
 	flag.Parse()
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "run":
+			os.Exit(run(flag.Args()[1:], *interpreter))
+		case "build":
+			os.Exit(build(flag.Args()[1:]))
+		case "disasm":
+			os.Exit(disasm(flag.Args()[1:]))
+		}
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -25,3 +44,137 @@ func main() {
 	fmt.Printf("Feel free to type in commands\n")
 	repl.Start(os.Stdin, os.Stdout, *interpreter)
 }
+
+// run executes `monkey run path/to/file.monkey [args...]` and returns the
+// process exit status. Files ending in BytecodeExt are loaded as compiled
+// bytecode and run on the VM.
+func run(args []string, interpreter bool) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: monkey [-interpreter] run FILE [ARGS...]\n")
+		return 2
+	}
+	if filepath.Ext(args[0]) == BytecodeExt {
+		if interpreter {
+			fmt.Fprintf(os.Stderr, "%s: compiled bytecode cannot be run by the interpreter\n", args[0])
+			return 2
+		}
+		return runBytecode(args[0], args[1:])
+	}
+	src, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	if err := script.Run(args[0], string(src), args[1:], interpreter); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runBytecode(path string, args []string) int {
+	bytecode, err := loadBytecode(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	if err := script.RunBytecode(bytecode, args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// build executes `monkey build [-O] FILE [-o OUTPUT]`, compiling FILE to
+// bytecode written to OUTPUT, which defaults to FILE with the BytecodeExt
+// extension.
+func build(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	output := flags.String("o", "", "write the bytecode to `OUTPUT`")
+	optimize := flags.Bool("O", false, "enable compiler optimizations")
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "usage: monkey build [-O] FILE [-o OUTPUT]\n")
+		return 2
+	}
+	// accept the flags before or after FILE
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		return usage()
+	}
+	path := flags.Arg(0)
+	if err := flags.Parse(flags.Args()[1:]); err != nil || flags.NArg() != 0 {
+		return usage()
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + BytecodeExt
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	bytecode, err := script.Compile(path, string(src), *optimize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	if err := bytecode.Encode(f); err != nil {
+		f.Close()
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *output, err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// disasm executes `monkey disasm [-O] FILE`, printing the disassembly of a
+// source file, compiled with optimizations if -O is given, or of compiled
+// bytecode.
+func disasm(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	optimize := flags.Bool("O", false, "enable compiler optimizations")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: monkey disasm [-O] FILE\n")
+		return 2
+	}
+	path := flags.Arg(0)
+	var bytecode *compiler.Bytecode
+	var err error
+	if filepath.Ext(path) == BytecodeExt {
+		bytecode, err = loadBytecode(path)
+	} else {
+		var src []byte
+		src, err = os.ReadFile(path)
+		if err == nil {
+			bytecode, err = script.Compile(path, string(src), *optimize)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	fmt.Print(compiler.Disassemble(bytecode))
+	return 0
+}
+
+// loadBytecode reads a file written by build.
+func loadBytecode(path string) (*compiler.Bytecode, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	bytecode, err := compiler.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return bytecode, nil
+}
@@ -2,132 +2,396 @@ package object
 
 import (
 	"fmt"
+	"sort"
+	"unicode/utf8"
 )
 
-var Builtins = []struct {
-	Name    string
-	Builtin *Builtin
-}{
+// standardBuiltins are the builtins of every registry, in the order of their
+// OpGetBuiltin indices. The builtins of the other files follow them.
+var standardBuiltins = []*Builtin{
 	{
-		"len",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
-				switch arg := args[0].(type) {
-				case *Array:
-					return &Integer{Value: int64(len(arg.Elements))}
-				case *String:
-					return &Integer{Value: int64(len(arg.Value))}
-				default:
-					return newError("argument to `len` not supported, got %s", args[0].Type())
-				}
-			},
+		Name:  "len",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			switch arg := args[0].(type) {
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Tuple:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *String:
+				return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+			default:
+				return newError("argument to `len` not supported, got %s", args[0].Type())
+			}
 		},
 	},
 	{
-		"puts",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				for _, a := range args {
-					fmt.Println(a.Inspect())
-				}
+		Name:  "puts",
+		Arity: Variadic,
+		Fn: func(args ...Object) Object {
+			for _, a := range args {
+				fmt.Println(a.Inspect())
+			}
+			return nil
+		},
+	},
+	{
+		Name:  "first",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[0]
+			} else {
 				return nil
-			},
+			}
 		},
 	},
 	{
-		"first",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=%d", len(args), 1)
-				}
-				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
-				}
-				arr := args[0].(*Array)
-				if len(arr.Elements) > 0 {
-					return arr.Elements[0]
-				} else {
-					return nil
-				}
-			},
+		Name:  "last",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if length > 0 {
+				return arr.Elements[length-1]
+			} else {
+				return nil
+			}
 		},
 	},
 	{
-		"last",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=%d", len(args), 1)
+		Name:  "rest",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `rest` must be ARRAY, got=%s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if length > 0 {
+				newElements := make([]Object, length-1)
+				copy(newElements, arr.Elements[1:length])
+				return &Array{Elements: newElements}
+			} else {
+				return nil
+			}
+		},
+	},
+	{
+		Name:  "push",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			newElements := make([]Object, length+1)
+			copy(newElements, arr.Elements)
+			newElements[length] = args[1]
+			return &Array{Elements: newElements}
+		},
+	},
+	{
+		Name:  "map",
+		Arity: 2,
+		CallerFn: func(caller Caller, args ...Object) Object {
+			arr, fn, err := arrayAndFunction("map", args[0], args[1])
+			if err != nil {
+				return err
+			}
+			elements := make([]Object, len(arr.Elements))
+			for i, e := range arr.Elements {
+				result := callFunction(caller, fn, e)
+				if isError(result) {
+					return result
 				}
-				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
+				elements[i] = result
+			}
+			return &Array{Elements: elements}
+		},
+	},
+	{
+		Name:  "filter",
+		Arity: 2,
+		CallerFn: func(caller Caller, args ...Object) Object {
+			arr, fn, err := arrayAndFunction("filter", args[0], args[1])
+			if err != nil {
+				return err
+			}
+			elements := []Object{}
+			for _, e := range arr.Elements {
+				result := callFunction(caller, fn, e)
+				if isError(result) {
+					return result
 				}
-				arr := args[0].(*Array)
-				length := len(arr.Elements)
-				if length > 0 {
-					return arr.Elements[length-1]
-				} else {
-					return nil
+				if IsTruthy(result) {
+					elements = append(elements, e)
 				}
-			},
+			}
+			return &Array{Elements: elements}
 		},
 	},
 	{
-		"rest",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=%d", len(args), 1)
+		Name:  "reduce",
+		Arity: 3,
+		CallerFn: func(caller Caller, args ...Object) Object {
+			arr, fn, err := arrayAndFunction("reduce", args[0], args[2])
+			if err != nil {
+				return err
+			}
+			acc := args[1]
+			for _, e := range arr.Elements {
+				acc = callFunction(caller, fn, acc, e)
+				if isError(acc) {
+					return acc
 				}
-				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `rest` must be ARRAY, got=%s", args[0].Type())
-				}
-				arr := args[0].(*Array)
-				length := len(arr.Elements)
-				if length > 0 {
-					newElements := make([]Object, length-1)
-					copy(newElements, arr.Elements[1:length])
-					return &Array{Elements: newElements}
-				} else {
-					return nil
+			}
+			return acc
+		},
+	},
+	{
+		Name:  "each",
+		Arity: 2,
+		CallerFn: func(caller Caller, args ...Object) Object {
+			arr, fn, err := arrayAndFunction("each", args[0], args[1])
+			if err != nil {
+				return err
+			}
+			for _, e := range arr.Elements {
+				if result := callFunction(caller, fn, e); isError(result) {
+					return result
 				}
-			},
+			}
+			return nil
+		},
+	},
+	{
+		Name:  "any",
+		Arity: 2,
+		CallerFn: func(caller Caller, args ...Object) Object {
+			return findTruthy(caller, "any", args, true)
+		},
+	},
+	{
+		Name:  "all",
+		Arity: 2,
+		CallerFn: func(caller Caller, args ...Object) Object {
+			return findTruthy(caller, "all", args, false)
 		},
 	},
 	{
-		"push",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 2 {
-					return newError("wrong number of arguments. got=%d, want=%d", len(args), 2)
+		Name:  "sort_by",
+		Arity: 2,
+		CallerFn: func(caller Caller, args ...Object) Object {
+			arr, fn, err := arrayAndFunction("sort_by", args[0], args[1])
+			if err != nil {
+				return err
+			}
+			keys := make([]Object, len(arr.Elements))
+			for i, e := range arr.Elements {
+				key := callFunction(caller, fn, e)
+				if isError(key) {
+					return key
 				}
-				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+				if !isNumber(key) && key.Type() != STRING_OBJ {
+					return newError("`sort_by` keys must be numbers or strings, got %s", key.Type())
 				}
-				arr := args[0].(*Array)
-				length := len(arr.Elements)
-				newElements := make([]Object, length+1)
-				copy(newElements, arr.Elements)
-				newElements[length] = args[1]
-				return &Array{Elements: newElements}
-			},
+				if i > 0 && isNumber(key) != isNumber(keys[0]) {
+					return newError("`sort_by` keys cannot be compared: %s and %s", keys[0].Type(), key.Type())
+				}
+				keys[i] = key
+			}
+			order := make([]int, len(keys))
+			for i := range order {
+				order[i] = i
+			}
+			sort.SliceStable(order, func(i, j int) bool {
+				return lessKey(keys[order[i]], keys[order[j]])
+			})
+			elements := make([]Object, len(order))
+			for i, index := range order {
+				elements[i] = arr.Elements[index]
+			}
+			return &Array{Elements: elements}
 		},
 	},
 }
 
+// arrayAndFunction checks the array and the function given to the builtin
+// called name.
+func arrayAndFunction(name string, arr Object, fn Object) (*Array, Object, *Error) {
+	if arr.Type() != ARRAY_OBJ {
+		return nil, nil, newError("argument to `%s` must be ARRAY, got %s", name, arr.Type())
+	}
+	switch fn.(type) {
+	case *Function, *Closure, *Builtin:
+		return arr.(*Array), fn, nil
+	default:
+		return nil, nil, newError("argument to `%s` must be a function, got %s", name, fn.Type())
+	}
+}
+
+// callFunction calls fn through caller, returning null for a nil result.
+func callFunction(caller Caller, fn Object, args ...Object) Object {
+	if result := caller.CallFunction(fn, args...); result != nil {
+		return result
+	}
+	return NULL
+}
+
+// findTruthy returns whether the function in args returns a truthy value
+// for some element of the array in args if want is true, or for all of them
+// if it is false.
+func findTruthy(caller Caller, name string, args []Object, want bool) Object {
+	arr, fn, err := arrayAndFunction(name, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	for _, e := range arr.Elements {
+		result := callFunction(caller, fn, e)
+		if isError(result) {
+			return result
+		}
+		if IsTruthy(result) == want {
+			return nativeBool(want)
+		}
+	}
+	return nativeBool(!want)
+}
+
+// lessKey orders two numbers, or two strings.
+func lessKey(a, b Object) bool {
+	if a, ok := a.(*String); ok {
+		return a.Value < b.(*String).Value
+	}
+	ai, aInt := a.(*Integer)
+	bi, bInt := b.(*Integer)
+	if aInt && bInt {
+		return ai.Value < bi.Value
+	}
+	af, _ := ToFloat(a)
+	bf, _ := ToFloat(b)
+	return af < bf
+}
+
+func isNumber(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == FLOAT_OBJ
+}
+
+func isError(obj Object) bool {
+	return obj != nil && obj.Type() == ERROR_OBJ
+}
+
+func nativeBool(value bool) *Boolean {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
+
 func newError(format string, a ...interface{}) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func GetBuiltinByName(name string) *Builtin {
-	for _, def := range Builtins {
-		if def.Name == name {
-			return def.Builtin
+// MaxBuiltins is the number of builtins a registry can hold, limited by the
+// operand of OpGetBuiltin.
+const MaxBuiltins = 256
+
+// BuiltinRegistry is an ordered set of builtins. The index of a builtin is
+// its operand of OpGetBuiltin, so the registry a program is compiled with
+// must be the one it runs with.
+type BuiltinRegistry struct {
+	builtins []*Builtin
+	index    map[string]int
+	frozen   bool
+}
+
+// Builtins holds the standard builtins, which programs use unless they are
+// given a registry of their own. It cannot be extended.
+var Builtins = newFrozenRegistry()
+
+func newFrozenRegistry() *BuiltinRegistry {
+	r := NewBuiltinRegistry()
+	r.frozen = true
+	return r
+}
+
+// NewBuiltinRegistry returns a registry of the standard builtins, which can
+// be extended with Register.
+func NewBuiltinRegistry() *BuiltinRegistry {
+	r := &BuiltinRegistry{index: make(map[string]int)}
+	for _, builtins := range [][]*Builtin{standardBuiltins, stringBuiltins, hashBuiltins} {
+		for _, b := range builtins {
+			r.add(b)
 		}
 	}
+	return r
+}
+
+func (r *BuiltinRegistry) add(b *Builtin) {
+	r.index[b.Name] = len(r.builtins)
+	r.builtins = append(r.builtins, b)
+}
+
+// Register adds a builtin called name, taking arity arguments or any number
+// if arity is Variadic, and returns it.
+func (r *BuiltinRegistry) Register(name string, arity int, fn BuiltinFunction) (*Builtin, error) {
+	if fn == nil {
+		return nil, fmt.Errorf("builtin %s: no function", name)
+	}
+	return r.register(&Builtin{Name: name, Arity: arity, Fn: fn})
+}
+
+// RegisterWithCaller is like Register, for a builtin calling the functions
+// it is given through a Caller.
+func (r *BuiltinRegistry) RegisterWithCaller(name string, arity int, fn CallerFunction) (*Builtin, error) {
+	if fn == nil {
+		return nil, fmt.Errorf("builtin %s: no function", name)
+	}
+	return r.register(&Builtin{Name: name, Arity: arity, CallerFn: fn})
+}
+
+func (r *BuiltinRegistry) register(b *Builtin) (*Builtin, error) {
+	switch {
+	case r.frozen:
+		return nil, fmt.Errorf("builtin %s: registry cannot be extended", b.Name)
+	case b.Name == "":
+		return nil, fmt.Errorf("builtin without a name")
+	case b.Arity < Variadic:
+		return nil, fmt.Errorf("builtin %s: invalid arity %d", b.Name, b.Arity)
+	case len(r.builtins) >= MaxBuiltins:
+		return nil, fmt.Errorf("builtin %s: more than %d builtins", b.Name, MaxBuiltins)
+	}
+	if _, ok := r.index[b.Name]; ok {
+		return nil, fmt.Errorf("builtin %s: already registered", b.Name)
+	}
+	r.add(b)
+	return b, nil
+}
+
+// Len returns the number of builtins in r.
+func (r *BuiltinRegistry) Len() int { return len(r.builtins) }
+
+// At returns the builtin with the given index, or nil if there is none.
+func (r *BuiltinRegistry) At(index int) *Builtin {
+	if index < 0 || index >= len(r.builtins) {
+		return nil
+	}
+	return r.builtins[index]
+}
+
+// Lookup returns the builtin called name, or nil if there is none.
+func (r *BuiltinRegistry) Lookup(name string) *Builtin {
+	if i, ok := r.index[name]; ok {
+		return r.builtins[i]
+	}
 	return nil
 }
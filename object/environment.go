@@ -1,5 +1,7 @@
 package object
 
+import "context"
+
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
 	return &Environment{store: s}
@@ -14,6 +16,65 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	ctx   context.Context // set on the outermost environment only
+	quota *MemoryQuota    // set on the outermost environment only
+
+	builtins *BuiltinRegistry // set on the outermost environment only
+}
+
+// Context returns the context of the evaluations running in e, which is
+// held by its outermost environment. It is nil if none was set.
+func (e *Environment) Context() context.Context {
+	return e.outermost().ctx
+}
+
+// SetContext sets the context of the evaluations running in e, and in every
+// environment sharing its outermost environment, and returns the previous
+// one.
+func (e *Environment) SetContext(ctx context.Context) context.Context {
+	root := e.outermost()
+	prev := root.ctx
+	root.ctx = ctx
+	return prev
+}
+
+// MemoryQuota returns the memory quota of the evaluations running in e, which
+// is held by its outermost environment. It is nil if none was set.
+func (e *Environment) MemoryQuota() *MemoryQuota {
+	return e.outermost().quota
+}
+
+// SetMemoryQuota sets the memory quota of the evaluations running in e, and
+// in every environment sharing its outermost environment, and returns the
+// previous one.
+func (e *Environment) SetMemoryQuota(q *MemoryQuota) *MemoryQuota {
+	root := e.outermost()
+	prev := root.quota
+	root.quota = q
+	return prev
+}
+
+// Builtins returns the builtins of the evaluations running in e, which are
+// held by its outermost environment. They are the standard Builtins if none
+// were set.
+func (e *Environment) Builtins() *BuiltinRegistry {
+	if b := e.outermost().builtins; b != nil {
+		return b
+	}
+	return Builtins
+}
+
+// SetBuiltins sets the builtins of the evaluations running in e, and in every
+// environment sharing its outermost environment.
+func (e *Environment) SetBuiltins(b *BuiltinRegistry) {
+	e.outermost().builtins = b
+}
+
+func (e *Environment) outermost() *Environment {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -24,6 +85,18 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Assign updates name in the innermost environment that defines it, unlike
+// Set which always binds name in e. ok is false if name is not defined.
+func (e *Environment) Assign(name string, val Object) (Object, bool) {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return val, true
+		}
+	}
+	return nil, false
+}
+
 func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
@@ -0,0 +1,112 @@
+package object
+
+// Equal reports whether a == b holds in Monkey, on either backend:
+//
+//	numbers     equal values, an integer equal to a float with its value
+//	strings     equal contents
+//	booleans    equal values
+//	null        null is equal only to null
+//	arrays      equal lengths and equal elements, also when compared to tuples
+//	hashes      equal keys, each with equal values, in any order
+//
+// Values of other types, like functions, are equal only to themselves.
+// Values with the same hash key are equal. Arrays and hashes holding
+// themselves are equal if no difference is found.
+func Equal(a, b Object) bool {
+	return equal(a, b, nil)
+}
+
+// comparison is a pair of containers being compared, which holds on a cycle
+// back to it.
+type comparison struct{ a, b Object }
+
+func equal(a, b Object, seen map[comparison]bool) bool {
+	if a == b {
+		return true
+	}
+	switch a := a.(type) {
+	case *Integer:
+		if b, ok := b.(*Integer); ok {
+			return a.Value == b.Value
+		}
+		f, ok := ToFloat(b)
+		return ok && float64(a.Value) == f
+	case *Float:
+		f, ok := ToFloat(b)
+		return ok && a.Value == f
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		elements, ok := sequence(b)
+		return ok && equalElements(a, b, a.Elements, elements, seen)
+	case *Tuple:
+		elements, ok := sequence(b)
+		return ok && equalElements(a, b, a.Elements, elements, seen)
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		seen, done := enter(a, b, seen)
+		if done {
+			return true
+		}
+		for key, pair := range a.Pairs {
+			other, ok := b.Pairs[key]
+			if !ok || !equal(pair.Value, other.Value, seen) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func sequence(obj Object) ([]Object, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.Elements, true
+	case *Tuple:
+		return obj.Elements, true
+	default:
+		return nil, false
+	}
+}
+
+func equalElements(a, b Object, x, y []Object, seen map[comparison]bool) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	seen, done := enter(a, b, seen)
+	if done {
+		return true
+	}
+	for i := range x {
+		if !equal(x[i], y[i], seen) {
+			return false
+		}
+	}
+	return true
+}
+
+// enter records the comparison of the containers a and b, returning true if
+// it is in progress already.
+func enter(a, b Object, seen map[comparison]bool) (map[comparison]bool, bool) {
+	if seen == nil {
+		seen = make(map[comparison]bool)
+	}
+	c := comparison{a, b}
+	if seen[c] {
+		return seen, true
+	}
+	seen[c] = true
+	return seen, false
+}
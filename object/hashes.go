@@ -0,0 +1,104 @@
+package object
+
+// hashBuiltins follow stringBuiltins in every registry.
+var hashBuiltins = []*Builtin{
+	{
+		// tuple makes the composite keys of hashes
+		Name:  "tuple",
+		Arity: Variadic,
+		Fn: func(args ...Object) Object {
+			return NewTuple(args)
+		},
+	},
+	{
+		Name:  "keys",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError("argument to `keys` must be HASH, got %s", args[0].Type())
+			}
+			pairs := hash.OrderedPairs()
+			keys := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				keys[i] = pair.Key
+			}
+			return &Array{Elements: keys}
+		},
+	},
+	{
+		Name:  "values",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError("argument to `values` must be HASH, got %s", args[0].Type())
+			}
+			pairs := hash.OrderedPairs()
+			values := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				values[i] = pair.Value
+			}
+			return &Array{Elements: values}
+		},
+	},
+	{
+		Name:  "has",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			hash, key, err := hashAndKey("has", args)
+			if err != nil {
+				return err
+			}
+			_, ok := hash.Pairs[key]
+			return nativeBool(ok)
+		},
+	},
+	{
+		// delete removes a key from a hash in place, like index assignment
+		// sets one, and reports whether it was there.
+		Name:  "delete",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			hash, key, err := hashAndKey("delete", args)
+			if err != nil {
+				return err
+			}
+			return nativeBool(hash.Delete(key))
+		},
+	},
+	{
+		Name:  "merge",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			for _, arg := range args {
+				if arg.Type() != HASH_OBJ {
+					return newError("argument to `merge` must be HASH, got %s", arg.Type())
+				}
+			}
+			a, b := args[0].(*Hash), args[1].(*Hash)
+			merged := NewHash(len(a.Pairs) + len(b.Pairs))
+			for _, h := range []*Hash{a, b} {
+				for _, pair := range h.OrderedPairs() {
+					key, _ := HashKeyOf(pair.Key)
+					merged.Set(key, pair)
+				}
+			}
+			return merged
+		},
+	},
+}
+
+// hashAndKey returns the hash and the hash key of the arguments of the
+// builtin called name.
+func hashAndKey(name string, args []Object) (*Hash, HashKey, *Error) {
+	hash, ok := args[0].(*Hash)
+	if !ok {
+		return nil, HashKey{}, newError("argument to `%s` must be HASH, got %s", name, args[0].Type())
+	}
+	key, ok := HashKeyOf(args[1])
+	if !ok {
+		return nil, HashKey{}, newError("unusable as hash key: %s", args[1].Type())
+	}
+	return hash, key, nil
+}
@@ -0,0 +1,51 @@
+package object
+
+import "unicode/utf8"
+
+// Iterator steps through the elements of a collection in a for-in loop:
+// the elements of an array or a tuple, the keys of a hash or the characters of a string.
+type Iterator struct {
+	elements []Object
+	index    int
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+
+func (it *Iterator) Inspect() string { return "iterator" }
+
+// NewIterator returns an Iterator over obj. ok is false if obj is not iterable.
+// Hash keys are visited in insertion order.
+func NewIterator(obj Object) (it *Iterator, ok bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return &Iterator{elements: obj.Elements}, true
+	case *Tuple:
+		return &Iterator{elements: obj.Elements}, true
+	case *Hash:
+		pairs := obj.OrderedPairs()
+		keys := make([]Object, len(pairs))
+		for i, pair := range pairs {
+			keys[i] = pair.Key
+		}
+		return &Iterator{elements: keys}, true
+	case *String:
+		chars := []Object{}
+		for i := range obj.Value {
+			_, size := utf8.DecodeRuneInString(obj.Value[i:])
+			chars = append(chars, &String{Value: obj.Value[i : i+size]})
+		}
+		return &Iterator{elements: chars}, true
+	default:
+		return nil, false
+	}
+}
+
+// Next returns the next element. ok is false once the iterator is exhausted.
+func (it *Iterator) Next() (element Object, ok bool) {
+	if it.index >= len(it.elements) {
+		return nil, false
+	}
+	element = it.elements[it.index]
+	it.index++
+	return element, true
+}
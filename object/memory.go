@@ -0,0 +1,103 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMemoryLimit is the error of a run that allocated more than its memory
+// quota.
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// estimated sizes in bytes of the allocations made for values, on 64-bit
+// platforms
+const (
+	stringSize  = 16 // the String and its string header
+	arraySize   = 32 // the Array and its slice header
+	elementSize = 16 // an interface value in a slice
+	hashSize    = 56 // the Hash and its map header
+	pairSize    = 64 // a HashKey and a HashPair in a map, with the map's overhead
+)
+
+// SizeOf estimates the bytes allocated for obj itself, without the values it
+// holds, for the types a MemoryQuota accounts: strings, arrays, tuples and
+// hashes. It is 0 for other types.
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return stringSize + int64(len(obj.Value))
+	case *Array:
+		return arraySize + elementSize*int64(len(obj.Elements))
+	case *Tuple:
+		return arraySize + elementSize*int64(len(obj.Elements))
+	case *Hash:
+		return hashSize + pairSize*int64(len(obj.Pairs))
+	}
+	return 0
+}
+
+// MemoryQuota accounts the memory a run allocates for strings, arrays and
+// hashes against a limit. It counts every allocation, whether or not the
+// value is still in use. All methods accept a nil *MemoryQuota, which
+// accounts nothing.
+type MemoryQuota struct {
+	limit int64
+	used  int64
+}
+
+// NewMemoryQuota returns a quota of limit bytes.
+func NewMemoryQuota(limit int64) *MemoryQuota {
+	return &MemoryQuota{limit: limit}
+}
+
+// Used returns the number of bytes accounted so far.
+func (q *MemoryQuota) Used() int64 {
+	if q == nil {
+		return 0
+	}
+	return q.used
+}
+
+// Allocate accounts n bytes. It returns an error wrapping ErrMemoryLimit
+// once the quota is exceeded.
+func (q *MemoryQuota) Allocate(n int64) error {
+	if q == nil {
+		return nil
+	}
+	q.used += n
+	if q.used > q.limit {
+		return fmt.Errorf("%w: allocated more than %d bytes", ErrMemoryLimit, q.limit)
+	}
+	return nil
+}
+
+// Account accounts a newly created value.
+func (q *MemoryQuota) Account(obj Object) error {
+	return q.Allocate(SizeOf(obj))
+}
+
+// AccountPair accounts a pair added to an existing hash.
+func (q *MemoryQuota) AccountPair() error {
+	return q.Allocate(pairSize)
+}
+
+// AccountResult accounts the result of a builtin called with args, unless it
+// is one of args or one of their elements, which are accounted already.
+func (q *MemoryQuota) AccountResult(result Object, args []Object) error {
+	if q == nil || SizeOf(result) == 0 {
+		return nil
+	}
+	for _, arg := range args {
+		if arg == result {
+			return nil
+		}
+		if arr, ok := arg.(*Array); ok {
+			for _, elem := range arr.Elements {
+				if elem == result {
+					return nil
+				}
+			}
+		}
+	}
+	return q.Account(result)
+}
@@ -3,9 +3,12 @@ package object
 import (
 	"bytes"
 	"fmt"
-	"hash/fnv"
+	"math"
 	"monkey/ast"
 	"monkey/code"
+	"monkey/token"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -18,6 +21,7 @@ type Object interface {
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	FLOAT_OBJ             = "FLOAT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	NULL_OBJ              = "NULL"
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
@@ -31,6 +35,12 @@ const (
 	MACRO_OBJ             = "MACRO"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE"
+	ITERATOR_OBJ          = "ITERATOR"
+	CELL_OBJ              = "CELL"
+	BREAK_OBJ             = "BREAK"
+	CONTINUE_OBJ          = "CONTINUE"
+	TAIL_CALL_OBJ         = "TAIL_CALL"
+	TUPLE_OBJ             = "TUPLE"
 )
 
 type Integer struct {
@@ -41,6 +51,33 @@ func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
 func (i *Integer) Inspect() string { return fmt.Sprintf("%d", i.Value) }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0" // keep 3.0 distinguishable from the integer 3
+	}
+	return s
+}
+
+// ToFloat converts a numeric object to float64. ok is false if obj is
+// neither an Integer nor a Float.
+func ToFloat(obj Object) (value float64, ok bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value), true
+	case *Float:
+		return obj.Value, true
+	default:
+		return 0, false
+	}
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -51,6 +88,27 @@ func (b *Boolean) Inspect() string { return fmt.Sprintf("%t", b.Value) }
 
 type Null struct{}
 
+// The values of true, false and null, shared by both backends and by
+// conversions from Go, so that they can be compared by identity.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
+// IsTruthy reports whether a condition holding obj is met: every value but
+// false and null is truthy.
+func IsTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	default:
+		return true
+	}
+}
+
 func (n *Null) Type() ObjectType { return NULL_OBJ }
 
 func (n *Null) Inspect() string { return "null" }
@@ -63,15 +121,54 @@ func (r *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
 func (r *ReturnValue) Inspect() string { return r.Value.Inspect() }
 
+// Break and Continue signal a break or continue statement to the enclosing
+// loop in the evaluator, the way ReturnValue does for functions.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+func (b *Break) Inspect() string { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
+func (c *Continue) Inspect() string { return "continue" }
+
+// TailCall is a call in tail position that the evaluator returns instead of
+// applying, so that the function making it returns first and the stack does
+// not grow with the call.
+type TailCall struct {
+	Fn   *Function
+	Args []Object
+	Pos  token.Position // position of the call
+}
+
+func (t *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+
+func (t *TailCall) Inspect() string { return "tail call" }
+
 type Error struct {
 	Message string
+	Pos     token.Position // where the error was raised, if known
+	Trace   []TraceFrame   // the calls active when it was raised, innermost first
+	Err     error          // the Go error it was raised for, if any
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
-func (e *Error) Inspect() string { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
+
+// StackTrace renders the error's stack trace, one frame per line.
+func (e *Error) StackTrace() string { return FormatTrace(e.Trace) }
 
 type Function struct {
+	Name       string // name of the let binding it was defined in, if any
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -107,8 +204,40 @@ func (s *String) Inspect() string { return s.Value }
 
 type BuiltinFunction func(args ...Object) Object
 
+// Variadic is the arity of a builtin taking any number of arguments.
+const Variadic = -1
+
+// Caller applies Monkey functions for builtins taking them as arguments. It
+// runs them on the backend that called the builtin.
+type Caller interface {
+	// CallFunction calls fn, a function or a builtin, with args and returns
+	// its result, or an *Error if the call failed. The builtin must return
+	// such an error as its own result.
+	CallFunction(fn Object, args ...Object) Object
+}
+
+// CallerFunction is the function of a builtin calling functions through a
+// Caller.
+type CallerFunction func(caller Caller, args ...Object) Object
+
 type Builtin struct {
-	Fn BuiltinFunction
+	Name  string
+	Arity int // number of arguments, or Variadic
+	Fn    BuiltinFunction
+	// CallerFn is called in place of Fn if it is set.
+	CallerFn CallerFunction
+}
+
+// Call calls the builtin's function after checking the number of arguments.
+// caller applies the functions the builtin is given.
+func (b *Builtin) Call(caller Caller, args ...Object) Object {
+	if b.Arity != Variadic && len(args) != b.Arity {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), b.Arity)
+	}
+	if b.CallerFn != nil {
+		return b.CallerFn(caller, args...)
+	}
+	return b.Fn(args...)
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -121,12 +250,20 @@ type Array struct {
 
 func (a *Array) Type() ObjectType { return ARRAY_OBJ }
 
-func (a *Array) Inspect() string {
+func (a *Array) Inspect() string { return a.inspect(nil) }
+
+func (a *Array) inspect(inProgress map[Object]bool) string {
+	if inProgress[a] {
+		return "[...]"
+	}
+	inProgress = enterInspect(a, inProgress)
+	defer delete(inProgress, a)
+
 	var out bytes.Buffer
 
 	elements := []string{}
 	for _, el := range a.Elements {
-		elements = append(elements, el.Inspect())
+		elements = append(elements, inspectElement(el, inProgress))
 	}
 
 	out.WriteString("[")
@@ -136,15 +273,115 @@ func (a *Array) Inspect() string {
 	return out.String()
 }
 
+// Tuple is an immutable sequence of values. Tuples of values that can be hash
+// keys can be keys too.
+type Tuple struct {
+	Elements []Object
+}
+
+// NewTuple returns a tuple of elements, turning arrays among them into
+// tuples too, so that the tuple cannot change. An array holding itself
+// becomes a tuple holding itself.
+func NewTuple(elements []Object) *Tuple {
+	return newTuple(elements, make(map[*Array]*Tuple))
+}
+
+// newTuple reuses the tuples in frozen made of the arrays they map from.
+func newTuple(elements []Object, frozen map[*Array]*Tuple) *Tuple {
+	t := &Tuple{Elements: make([]Object, len(elements))}
+	for i, e := range elements {
+		if arr, ok := e.(*Array); ok {
+			inner, ok := frozen[arr]
+			if !ok {
+				inner = &Tuple{}
+				frozen[arr] = inner
+				inner.Elements = newTuple(arr.Elements, frozen).Elements
+			}
+			e = inner
+		}
+		t.Elements[i] = e
+	}
+	return t
+}
+
+func (t *Tuple) Type() ObjectType { return TUPLE_OBJ }
+
+func (t *Tuple) Inspect() string { return t.inspect(nil) }
+
+func (t *Tuple) inspect(inProgress map[Object]bool) string {
+	if inProgress[t] {
+		return "(...)"
+	}
+	inProgress = enterInspect(t, inProgress)
+	defer delete(inProgress, t)
+
+	elements := []string{}
+	for _, el := range t.Elements {
+		elements = append(elements, inspectElement(el, inProgress))
+	}
+	if len(elements) == 1 {
+		return "(" + elements[0] + ",)"
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
+// HashKey identifies a key of a hash. Keys compare equal exactly when the
+// values they identify are equal: strings and composite keys keep their
+// contents in Data, so that distinct keys never share an entry.
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
+	Data  string
 }
 
 type Hashable interface {
 	HashKey() HashKey
 }
 
+// HashKeyOf returns the hash key of obj, and false if obj cannot be a key.
+// Arrays and tuples can if their elements can and they do not hold
+// themselves; an array has the key of the tuple of its elements.
+func HashKeyOf(obj Object) (HashKey, bool) {
+	return hashKeyOf(obj, nil)
+}
+
+func hashKeyOf(obj Object, inProgress map[Object]bool) (HashKey, bool) {
+	switch obj := obj.(type) {
+	case Hashable:
+		return obj.HashKey(), true
+	case *Array:
+		return tupleKey(obj, obj.Elements, inProgress)
+	case *Tuple:
+		return tupleKey(obj, obj.Elements, inProgress)
+	default:
+		return HashKey{}, false
+	}
+}
+
+// tupleKey encodes the keys of the elements of seq so that different
+// sequences of keys never have the same encoding. It fails if seq is in
+// progress, being an element of itself.
+func tupleKey(seq Object, elements []Object, inProgress map[Object]bool) (HashKey, bool) {
+	if inProgress[seq] {
+		return HashKey{}, false
+	}
+	if inProgress == nil {
+		inProgress = make(map[Object]bool)
+	}
+	inProgress[seq] = true
+	defer delete(inProgress, seq)
+
+	var data strings.Builder
+	for _, e := range elements {
+		key, ok := hashKeyOf(e, inProgress)
+		if !ok {
+			return HashKey{}, false
+		}
+		fmt.Fprintf(&data, "%d:%s%d;%d:%s", len(key.Type), key.Type, key.Value, len(key.Data), key.Data)
+	}
+	return HashKey{Type: TUPLE_OBJ, Value: uint64(len(elements)), Data: data.String()}, true
+}
+
 func (b *Boolean) HashKey() HashKey {
 	var value uint64
 	if b.Value {
@@ -159,10 +396,17 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey of a float with an integral value equals the key of that integer,
+// as 1.0 == 1 holds.
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && math.Abs(f.Value) < 1<<63 {
+		return (&Integer{Value: int64(f.Value)}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
-	h := fnv.New64a()
-	h.Write([]byte(s.Value))
-	return HashKey{Type: s.Type(), Value: h.Sum64()}
+	return HashKey{Type: s.Type(), Data: s.Value}
 }
 
 type HashPair struct {
@@ -170,18 +414,102 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash maps hashable keys to values. It remembers the order in which its
+// keys were first set, which is the order of Inspect and of iteration, as
+// long as pairs are added with Set and removed with Delete. Pairs stored in
+// Pairs directly follow the others, sorted by key.
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	keys  []HashKey // in insertion order
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair, size), keys: make([]HashKey, 0, size)}
+}
+
+// Set stores pair under key. A new key goes after the others; a key already
+// set keeps its place and the key object it was first set with, as 1 for
+// 1.0, and only its value is replaced. An array key is stored as a tuple.
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if h.Pairs == nil {
+		h.Pairs = make(map[HashKey]HashPair)
+	}
+	if existing, ok := h.Pairs[key]; ok {
+		h.Pairs[key] = HashPair{Key: existing.Key, Value: pair.Value}
+		return
+	}
+	h.keys = append(h.keys, key)
+	if arr, ok := pair.Key.(*Array); ok {
+		// the key must not change with the array
+		pair.Key = NewTuple(arr.Elements)
+	}
+	h.Pairs[key] = pair
+}
+
+// Delete removes the pair stored under key and reports whether there was
+// one.
+func (h *Hash) Delete(key HashKey) bool {
+	if _, ok := h.Pairs[key]; !ok {
+		return false
+	}
+	delete(h.Pairs, key)
+	for i, k := range h.keys {
+		if k == key {
+			h.keys = append(h.keys[:i], h.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// OrderedPairs returns the pairs of h in insertion order.
+func (h *Hash) OrderedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, k := range h.keys {
+		if pair, ok := h.Pairs[k]; ok {
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(pairs) == len(h.Pairs) {
+		return pairs
+	}
+	ordered := make(map[HashKey]bool, len(h.keys))
+	for _, k := range h.keys {
+		ordered[k] = true
+	}
+	var rest []HashPair
+	for k, pair := range h.Pairs {
+		if !ordered[k] {
+			rest = append(rest, pair)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		a, b := rest[i].Key, rest[j].Key
+		if a.Type() != b.Type() {
+			return a.Type() < b.Type()
+		}
+		return a.Inspect() < b.Inspect()
+	})
+	return append(pairs, rest...)
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 
-func (h *Hash) Inspect() string {
+func (h *Hash) Inspect() string { return h.inspect(nil) }
+
+func (h *Hash) inspect(inProgress map[Object]bool) string {
+	if inProgress[h] {
+		return "{...}"
+	}
+	inProgress = enterInspect(h, inProgress)
+	defer delete(inProgress, h)
+
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, v := range h.Pairs {
-		pairs = append(pairs, v.Key.Inspect()+":"+v.Value.Inspect())
+	for _, v := range h.OrderedPairs() {
+		pairs = append(pairs, inspectElement(v.Key, inProgress)+":"+inspectElement(v.Value, inProgress))
 	}
 
 	out.WriteString("{")
@@ -191,6 +519,28 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// container is implemented by the objects that can hold themselves. Their
+// inspect shows a container already being inspected as [...], (...) or
+// {...}, so that Inspect ends.
+type container interface {
+	inspect(inProgress map[Object]bool) string
+}
+
+func inspectElement(obj Object, inProgress map[Object]bool) string {
+	if c, ok := obj.(container); ok {
+		return c.inspect(inProgress)
+	}
+	return obj.Inspect()
+}
+
+func enterInspect(obj Object, inProgress map[Object]bool) map[Object]bool {
+	if inProgress == nil {
+		inProgress = make(map[Object]bool)
+	}
+	inProgress[obj] = true
+	return inProgress
+}
+
 type Quote struct {
 	Node ast.Node
 }
@@ -227,15 +577,27 @@ func (m *Macro) Inspect() string {
 }
 
 type CompiledFunction struct {
+	Name          string // name of the let binding it was defined in, if any
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	Positions     code.PositionTable
 }
 
 func (c *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
 
 func (c *CompiledFunction) Inspect() string { return fmt.Sprintf("CompiledFunction[%p]", c) }
 
+// Cell boxes a local variable captured by a closure in the VM, so that the
+// function defining it and every closure capturing it share its updates.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+
+func (c *Cell) Inspect() string { return c.Value.Inspect() }
+
 type Closure struct {
 	Fn   *CompiledFunction
 	Free []Object
@@ -0,0 +1,262 @@
+package object
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxStringLength bounds the strings built by repeat, which would otherwise
+// allocate before the memory of a run is accounted.
+const maxStringLength = 1 << 30
+
+// stringBuiltins follow standardBuiltins in every registry. Like len and
+// indexing, they count the characters (runes) of strings.
+var stringBuiltins = []*Builtin{
+	{
+		Name:  "split",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			s, sep, err := twoStrings("split", args)
+			if err != nil {
+				return err
+			}
+			parts := strings.Split(s, sep)
+			elements := make([]Object, len(parts))
+			for i, p := range parts {
+				elements[i] = &String{Value: p}
+			}
+			return &Array{Elements: elements}
+		},
+	},
+	{
+		Name:  "join",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument to `join` must be ARRAY, got %s", args[0].Type())
+			}
+			sep, ok := args[1].(*String)
+			if !ok {
+				return newError("argument to `join` must be STRING, got %s", args[1].Type())
+			}
+			parts := make([]string, len(arr.Elements))
+			for i, e := range arr.Elements {
+				s, ok := e.(*String)
+				if !ok {
+					return newError("`join` of a non-string element: %s", e.Type())
+				}
+				parts[i] = s.Value
+			}
+			return &String{Value: strings.Join(parts, sep.Value)}
+		},
+	},
+	{
+		Name:  "trim",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			return mapString("trim", args[0], strings.TrimSpace)
+		},
+	},
+	{
+		Name:  "contains",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			return testStrings("contains", args, strings.Contains)
+		},
+	},
+	{
+		Name:  "starts_with",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			return testStrings("starts_with", args, strings.HasPrefix)
+		},
+	},
+	{
+		Name:  "ends_with",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			return testStrings("ends_with", args, strings.HasSuffix)
+		},
+	},
+	{
+		Name:  "replace",
+		Arity: 3,
+		Fn: func(args ...Object) Object {
+			s, old, err := twoStrings("replace", args[:2])
+			if err != nil {
+				return err
+			}
+			replacement, ok := args[2].(*String)
+			if !ok {
+				return newError("argument to `replace` must be STRING, got %s", args[2].Type())
+			}
+			return &String{Value: strings.ReplaceAll(s, old, replacement.Value)}
+		},
+	},
+	{
+		Name:  "upper",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			return mapString("upper", args[0], strings.ToUpper)
+		},
+	},
+	{
+		Name:  "lower",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			return mapString("lower", args[0], strings.ToLower)
+		},
+	},
+	{
+		Name:  "repeat",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			s, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `repeat` must be STRING, got %s", args[0].Type())
+			}
+			count, ok := args[1].(*Integer)
+			if !ok {
+				return newError("argument to `repeat` must be INTEGER, got %s", args[1].Type())
+			}
+			if count.Value < 0 {
+				return newError("negative `repeat` count: %d", count.Value)
+			}
+			if len(s.Value) > 0 && count.Value > maxStringLength/int64(len(s.Value)) {
+				return newError("`repeat` result longer than %d bytes", maxStringLength)
+			}
+			return &String{Value: strings.Repeat(s.Value, int(count.Value))}
+		},
+	},
+	{
+		Name:  "index_of",
+		Arity: 2,
+		Fn: func(args ...Object) Object {
+			s, sub, err := twoStrings("index_of", args)
+			if err != nil {
+				return err
+			}
+			i := strings.Index(s, sub)
+			if i < 0 {
+				return &Integer{Value: -1}
+			}
+			return &Integer{Value: int64(utf8.RuneCountInString(s[:i]))}
+		},
+	},
+	{
+		Name:  "substring",
+		Arity: 3,
+		Fn: func(args ...Object) Object {
+			s, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `substring` must be STRING, got %s", args[0].Type())
+			}
+			start, ok := args[1].(*Integer)
+			if !ok {
+				return newError("argument to `substring` must be INTEGER, got %s", args[1].Type())
+			}
+			end, ok := args[2].(*Integer)
+			if !ok {
+				return newError("argument to `substring` must be INTEGER, got %s", args[2].Type())
+			}
+			// the bounds are clamped to the string, as by slicing
+			n := int64(utf8.RuneCountInString(s.Value))
+			from := clamp(start.Value, 0, n)
+			to := clamp(end.Value, from, n)
+			return &String{Value: s.Value[runeOffset(s.Value, from):runeOffset(s.Value, to)]}
+		},
+	},
+	{
+		Name:  "ord",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			s, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `ord` must be STRING, got %s", args[0].Type())
+			}
+			if s.Value == "" {
+				return newError("`ord` of an empty string")
+			}
+			r, _ := utf8.DecodeRuneInString(s.Value)
+			return &Integer{Value: int64(r)}
+		},
+	},
+	{
+		Name:  "chr",
+		Arity: 1,
+		Fn: func(args ...Object) Object {
+			code, ok := args[0].(*Integer)
+			if !ok {
+				return newError("argument to `chr` must be INTEGER, got %s", args[0].Type())
+			}
+			if code.Value < 0 || code.Value > utf8.MaxRune || !utf8.ValidRune(rune(code.Value)) {
+				return newError("invalid character code: %d", code.Value)
+			}
+			return &String{Value: string(rune(code.Value))}
+		},
+	},
+}
+
+// twoStrings returns the two string arguments of the builtin called name.
+func twoStrings(name string, args []Object) (string, string, *Error) {
+	for _, arg := range args {
+		if arg.Type() != STRING_OBJ {
+			return "", "", newError("argument to `%s` must be STRING, got %s", name, arg.Type())
+		}
+	}
+	return args[0].(*String).Value, args[1].(*String).Value, nil
+}
+
+func mapString(name string, arg Object, fn func(string) string) Object {
+	s, ok := arg.(*String)
+	if !ok {
+		return newError("argument to `%s` must be STRING, got %s", name, arg.Type())
+	}
+	return &String{Value: fn(s.Value)}
+}
+
+func testStrings(name string, args []Object, test func(string, string) bool) Object {
+	s, sub, err := twoStrings(name, args)
+	if err != nil {
+		return err
+	}
+	return nativeBool(test(s, sub))
+}
+
+// CharAt returns the character at index i of s, and false if s has no such
+// character.
+func CharAt(s string, i int64) (string, bool) {
+	if i < 0 {
+		return "", false
+	}
+	start := runeOffset(s, i)
+	if start == len(s) {
+		return "", false
+	}
+	_, size := utf8.DecodeRuneInString(s[start:])
+	return s[start : start+size], true
+}
+
+// runeOffset returns the byte offset of the character at index i of s, or
+// len(s) if s has no such character.
+func runeOffset(s string, i int64) int {
+	for offset := range s {
+		if i == 0 {
+			return offset
+		}
+		i--
+	}
+	return len(s)
+}
+
+func clamp(i, min, max int64) int64 {
+	switch {
+	case i < min:
+		return min
+	case i > max:
+		return max
+	default:
+		return i
+	}
+}
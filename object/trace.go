@@ -0,0 +1,51 @@
+package object
+
+import (
+	"fmt"
+	"monkey/token"
+	"strings"
+)
+
+// MainFunctionName names the top-level program in stack traces.
+const MainFunctionName = "<main>"
+
+// TraceFrame is one entry of a runtime error's stack trace: a function and
+// the position in it that was executing.
+type TraceFrame struct {
+	Function string // empty for anonymous functions
+	Pos      token.Position
+}
+
+func (f TraceFrame) String() string {
+	name := f.Function
+	if name == "" {
+		name = "<anonymous>"
+	}
+	if !f.Pos.IsValid() {
+		return "at " + name
+	}
+	return fmt.Sprintf("at %s (%s)", name, f.Pos)
+}
+
+// the number of innermost and outermost frames FormatTrace keeps of a long
+// trace, as deep recursion can leave thousands of identical frames
+const (
+	traceHead = 15
+	traceTail = 5
+)
+
+// FormatTrace renders a stack trace, innermost frame first, one indented
+// frame per line. The middle of a long trace is elided.
+func FormatTrace(trace []TraceFrame) string {
+	lines := []string{}
+	for i, f := range trace {
+		if len(trace) > traceHead+traceTail && i >= traceHead && i < len(trace)-traceTail {
+			if i == traceHead {
+				lines = append(lines, fmt.Sprintf("\t... %d more frames", len(trace)-traceHead-traceTail))
+			}
+			continue
+		}
+		lines = append(lines, "\t"+f.String())
+	}
+	return strings.Join(lines, "\n")
+}
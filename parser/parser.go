@@ -20,11 +20,13 @@ type Parser struct {
 	errors         []string
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+	loopDepth      int // number of loops enclosing the current token within its function
 }
 
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // x = y
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
@@ -35,6 +37,7 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
+	token.ASSIGN:   ASSIGN,
 	token.EQEQ:     EQUALS,
 	token.NEQ:      EQUALS,
 	token.LT:       LESSGREATER,
@@ -55,6 +58,7 @@ func New(l *lexer.Lexer) *Parser {
 
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -75,6 +79,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NEQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
@@ -146,14 +151,18 @@ func (p *Parser) curPrecedence() int {
 	return LOWEST
 }
 
-func (p *Parser) peekErrors(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.curToken.Type)
+// addError records a parser error prefixed with the source position it refers to.
+func (p *Parser) addError(pos token.Position, format string, a ...interface{}) {
+	msg := pos.String() + ": " + fmt.Sprintf(format, a...)
 	p.errors = append(p.errors, msg)
 }
 
+func (p *Parser) peekErrors(t token.TokenType) {
+	p.addError(p.peekToken.Pos, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
+}
+
 func (p *Parser) noPrefixParseError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.curToken.Pos, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) parseStatement() ast.Statement {
@@ -162,13 +171,21 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForInStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
-	stmt := &ast.LetStatement{Token: p.curToken}
+	stmt := &ast.LetStatement{Token: p.curToken, Doc: p.curToken.Doc}
 
 	if !p.expectPeek(token.IDENT) {
 		return nil
@@ -183,6 +200,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -204,6 +225,87 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForInStatement() *ast.ForInStatement {
+	stmt := &ast.ForInStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+	if p.loopDepth == 0 {
+		p.addError(p.curToken.Pos, "break outside of loop")
+	}
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+	if p.loopDepth == 0 {
+		p.addError(p.curToken.Pos, "continue outside of loop")
+	}
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
@@ -217,6 +319,16 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	return stmt
 }
 
+// parseFunctionBody parses the body of a function or macro literal. Loops
+// outside the body do not enclose it, so break and continue are not allowed
+// unless the body has loops of its own.
+func (p *Parser) parseFunctionBody() *ast.BlockStatement {
+	outerLoopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = outerLoopDepth }()
+	return p.parseBlockStatement()
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
@@ -270,18 +382,25 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Type)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken.Pos, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 	return &ast.IntegerLiteral{Token: p.curToken, Value: value}
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(p.curToken.Pos, "could not parse %q as float", p.curToken.Literal)
+		return nil
+	}
+	return &ast.FloatLiteral{Token: p.curToken, Value: value}
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	value, err := strconv.ParseBool(p.curToken.Literal)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as bool", p.curToken.Type)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken.Pos, "could not parse %q as bool", p.curToken.Literal)
 		return nil
 	}
 	return &ast.Boolean{Token: p.curToken, Value: value}
@@ -312,6 +431,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
@@ -335,7 +455,7 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
-	expr.Body = p.parseBlockStatement()
+	expr.Body = p.parseFunctionBody()
 
 	return expr
 }
@@ -362,6 +482,20 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{Token: p.curToken, Target: target}
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.addError(p.curToken.Pos, "cannot assign to %s", target.String())
+		return nil
+	}
+	p.nextToken()
+	// one level lower than ASSIGN, so a = b = c assigns right to left
+	expression.Value = p.parseExpression(ASSIGN - 1)
+	return expression
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{
 		Token: p.curToken,
@@ -414,7 +548,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
-	expression.Body = p.parseBlockStatement()
+	expression.Body = p.parseFunctionBody()
 	return expression
 }
 
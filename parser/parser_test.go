@@ -156,6 +156,25 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	}
 }
 
+func TestParsingHashLiteralKeyOrder(t *testing.T) {
+	input := `{"b": 1, "a": 2, "c": 3}`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+	if len(hash.Keys) != len(hash.Pairs) {
+		t.Fatalf("hash.Keys has wrong length. got=%d", len(hash.Keys))
+	}
+	if hash.String() != "{b:1, a:2, c:3}" {
+		t.Errorf("keys not in source order. got=%q", hash.String())
+	}
+}
+
 func TestParsingEmptyHashLiteral(t *testing.T) {
 	input := "{}"
 	l := lexer.New(input)
@@ -343,6 +362,38 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	testIntegerLiteral(t, stmt.Expression, 5)
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e9", 1e9},
+		{"2.5E-3", 2.5e-3},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("wrong number of statements. want=%d, got=%d", 1, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] not *ast.ExpressionStatement. got=%T(%+v)", program.Statements[0], program.Statements[0])
+		}
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestBooleanExpression(t *testing.T) {
 	testcase := []struct {
 		input    string
@@ -488,7 +539,11 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
-		}}
+		},
+		{"x = 1 + 2", "(x = (1 + 2))"},
+		{"a = b = c", "(a = (b = c))"},
+		{"a[0] = x == y", "((a[0]) = (x == y))"},
+	}
 
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -774,3 +829,203 @@ func TestCallExpressionParsing(t *testing.T) {
 	testInfixExpression(t, exp.Arguments[1], 2, "*", 3)
 	testInfixExpression(t, exp.Arguments[2], 4, "+", 5)
 }
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"let = 5;", "1:5: expected next token to be IDENT, got = instead"},
+		{"let x 5;", "1:7: expected next token to be =, got INT instead"},
+		{"let x = 5;\n  let y = );", "2:11: no prefix parse function for ) found"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q, got none", tt.input)
+		}
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong parser error. want=%q, got=%q", tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b;
+};`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	fn := stmt.Value.(*ast.FunctionLiteral)
+	body := fn.Body.Statements[0].(*ast.ExpressionStatement)
+	infix := body.Expression.(*ast.InfixExpression)
+
+	tests := []struct {
+		node           ast.Node
+		expectedLine   int
+		expectedColumn int
+	}{
+		{program, 1, 1},
+		{stmt, 1, 1},
+		{stmt.Name, 1, 5},
+		{fn, 1, 11},
+		{fn.Parameters[1], 1, 17},
+		{infix, 2, 5},
+		{infix.Left, 2, 3},
+	}
+	for i, tt := range tests {
+		pos := tt.node.Pos()
+		if pos.Line != tt.expectedLine || pos.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - %q position wrong. want=%d:%d, got=%d:%d", i, tt.node.String(), tt.expectedLine, tt.expectedColumn, pos.Line, pos.Column)
+		}
+	}
+}
+
+func TestLetStatementDoc(t *testing.T) {
+	input := `// answer is the answer.
+let answer = 42;`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.LetStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Doc != "answer is the answer." {
+		t.Errorf("stmt.Doc wrong. got=%q", stmt.Doc)
+	}
+}
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.LetStatement. got=%T", program.Statements[0])
+	}
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not *ast.FunctionLiteral. got=%T", stmt.Value)
+	}
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got=%q", function.Name)
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x; }", "while(x < 10) x"},
+		{"while (true) { break; }; 1;", "whiletrue break;1"},
+		{"for (x in [1, 2]) { continue; x }", "for(x in [1, 2]) continue;x"},
+		{"for (c in s) { };", "for(c in s) "},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	l := lexer.New("for (x in xs) { while (x) { break; } continue; }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ForInStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.ForInStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Variable.Value != "x" {
+		t.Errorf("stmt.Variable.Value not %q. got=%q", "x", stmt.Variable.Value)
+	}
+	if !testIdentifier(t, stmt.Iterable, "xs") {
+		return
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body does not contain 2 statements. got=%d", len(stmt.Body.Statements))
+	}
+	while, ok := stmt.Body.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("body.Statements[0] is not *ast.WhileStatement. got=%T", stmt.Body.Statements[0])
+	}
+	if _, ok := while.Body.Statements[0].(*ast.BreakStatement); !ok {
+		t.Errorf("while body is not *ast.BreakStatement. got=%T", while.Body.Statements[0])
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Errorf("body.Statements[1] is not *ast.ContinueStatement. got=%T", stmt.Body.Statements[1])
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"1 = 2;", "1:3: cannot assign to 1"},
+		{"f() = 2;", "1:5: cannot assign to f()"},
+		{"a + b = 2;", "1:7: cannot assign to (a + b)"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q, got none", tt.input)
+		}
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong parser error. want=%q, got=%q", tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestBreakContinueOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"break;", "1:1: break outside of loop"},
+		{"if (true) { continue; }", "1:13: continue outside of loop"},
+		{"while (true) { fn() { break; } }", "1:23: break outside of loop"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q, got none", tt.input)
+		}
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong parser error. want=%q, got=%q", tt.expectedError, errors[0])
+		}
+	}
+}
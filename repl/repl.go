@@ -9,10 +9,13 @@ import (
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/token"
 	"monkey/vm"
+	"strings"
 )
 
 const PROMPT = ">> "
+const CONTINUATION_PROMPT = ".. "
 
 func Start(in io.Reader, out io.Writer, useInterpreter bool) {
 	scanner := bufio.NewScanner(in)
@@ -22,19 +25,14 @@ func Start(in io.Reader, out io.Writer, useInterpreter bool) {
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalSize)
 
-	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable := compiler.NewSymbolTableWithBuiltins(object.Builtins)
 
 	for {
-		fmt.Printf("%s", PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		input, ok := readInput(scanner, out)
+		if !ok {
 			return
 		}
-		line := scanner.Text()
-		l := lexer.New(line)
+		l := lexer.New(input)
 		p := parser.New(l)
 
 		program := p.ParseProgram()
@@ -43,20 +41,24 @@ func Start(in io.Reader, out io.Writer, useInterpreter bool) {
 			continue
 		}
 
+		evaluator.DefineMacros(program, macroEnv)
+		expanded := evaluator.ExpandMacros(program, macroEnv)
+
 		if useInterpreter {
 			// Interpreter
-			evaluator.DefineMacros(program, macroEnv)
-			expanded := evaluator.ExpandMacros(program, macroEnv)
-
 			evaluated := evaluator.Eval(expanded, env)
 			if evaluated != nil {
 				io.WriteString(out, evaluated.Inspect())
 				io.WriteString(out, "\n")
 			}
+			if errObj, ok := evaluated.(*object.Error); ok && len(errObj.Trace) > 0 {
+				io.WriteString(out, errObj.StackTrace())
+				io.WriteString(out, "\n")
+			}
 		} else {
 			// VM
 			comp := compiler.NewWithState(symbolTable, constants)
-			err := comp.Compile(program)
+			err := comp.Compile(expanded)
 			if err != nil {
 				fmt.Fprintf(out, "Woops! Compilation failed:\n%s\n", err)
 				continue
@@ -65,13 +67,55 @@ func Start(in io.Reader, out io.Writer, useInterpreter bool) {
 			err = machine.Run()
 			if err != nil {
 				fmt.Fprintf(out, "Woops! Executing bytecode failed:\n%s\n", err)
+				if rtErr, ok := err.(*vm.RuntimeError); ok {
+					fmt.Fprintf(out, "%s\n", rtErr.StackTrace())
+				}
 				continue
 			}
-			lastPopped := machine.LastPoppedStackElem()
-			io.WriteString(out, lastPopped.Inspect())
-			io.WriteString(out, "\n")
+			// nothing is popped for an empty line or one of comments
+			if lastPopped := machine.LastPoppedStackElem(); lastPopped != nil {
+				io.WriteString(out, lastPopped.Inspect())
+				io.WriteString(out, "\n")
+			}
+		}
+	}
+}
+
+// readInput reads lines until they form a complete program, showing the
+// continuation prompt while the input is incomplete. ok is false once in is
+// exhausted.
+func readInput(scanner *bufio.Scanner, out io.Writer) (input string, ok bool) {
+	fmt.Fprintf(out, "%s", PROMPT)
+	lines := []string{}
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		input = strings.Join(lines, "\n")
+		if !isIncomplete(input) {
+			return input, true
+		}
+		fmt.Fprintf(out, "%s", CONTINUATION_PROMPT)
+	}
+	return input, len(lines) > 0
+}
+
+// isIncomplete reports whether input ends inside a string literal, a block
+// comment or an unclosed parenthesis, bracket or brace.
+func isIncomplete(input string) bool {
+	l := lexer.New(input)
+	depth := 0
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			depth--
+		case token.ILLEGAL:
+			if strings.HasPrefix(tok.Literal, "\"") || tok.Literal == "/*" {
+				return true
+			}
 		}
 	}
+	return depth > 0
 }
 
 func printParserErrors(out io.Writer, errors []string) {
@@ -0,0 +1,56 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestIsIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`let x = 1;`, false},
+		{`let f = fn(x) {`, true},
+		{"let f = fn(x) {\n  x\n};", false},
+		{`let a = [1, 2,`, true},
+		{`add(1,`, true},
+		{`let s = "foo`, true},
+		{`let s = "foo";`, false},
+		{`/* comment`, true},
+		{`}`, false},
+	}
+	for _, tt := range tests {
+		if got := isIncomplete(tt.input); got != tt.expected {
+			t.Errorf("isIncomplete(%q) wrong. want=%t, got=%t", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStartMultiLine(t *testing.T) {
+	input := `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+add(1,
+  2)
+`
+	for _, useInterpreter := range []bool{false, true} {
+		var out bytes.Buffer
+		Start(strings.NewReader(input), &out, useInterpreter)
+
+		expected := PROMPT + CONTINUATION_PROMPT + CONTINUATION_PROMPT + CONTINUATION_PROMPT
+		if useInterpreter {
+			expected += "fn(a,b) {\nlet sum = (a + b);sum\n}\n"
+		} else {
+			expected += "Closure["
+		}
+		if !strings.HasPrefix(out.String(), expected) {
+			t.Errorf("wrong output (interpreter=%t).\nwant prefix=%q\ngot        =%q", useInterpreter, expected, out.String())
+		}
+		if !strings.HasSuffix(out.String(), PROMPT+CONTINUATION_PROMPT+"3\n"+PROMPT) {
+			t.Errorf("wrong output (interpreter=%t). got=%q", useInterpreter, out.String())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package script
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+)
+
+// ArgsName is the global binding through which a script reads its arguments.
+const ArgsName = "args"
+
+// Run lexes, parses and executes a whole Monkey program. The program is
+// executed by the evaluator when useInterpreter is set, otherwise it is
+// compiled to bytecode and run on the VM.
+func Run(name string, input string, args []string, useInterpreter bool) error {
+	program, err := parse(name, input)
+	if err != nil {
+		return err
+	}
+	if useInterpreter {
+		return runInterpreter(name, program, args)
+	}
+	bytecode, err := compile(program, false)
+	if err != nil {
+		return err
+	}
+	return RunBytecode(bytecode, args)
+}
+
+// Compile compiles a whole Monkey program to bytecode that RunBytecode can
+// execute, for example after a round trip through Bytecode.Encode. The
+// compiler's optimizations are enabled by optimize.
+func Compile(name string, input string, optimize bool) (*compiler.Bytecode, error) {
+	program, err := parse(name, input)
+	if err != nil {
+		return nil, err
+	}
+	return compile(program, optimize)
+}
+
+// parse parses a program and expands its macros.
+func parse(name string, input string) (ast.Node, error) {
+	l := lexer.NewWithFilename(name, input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s: parse failed:\n\t%s", name, strings.Join(p.Errors(), "\n\t"))
+	}
+
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	return evaluator.ExpandMacros(program, macroEnv), nil
+}
+
+func runInterpreter(name string, program ast.Node, args []string) error {
+	env := object.NewEnvironment()
+	env.Set(ArgsName, argsArray(args))
+
+	evaluated := evaluator.Eval(program, env)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		pos := errObj.Pos
+		if !pos.IsValid() {
+			pos.Filename = name
+		}
+		return fmt.Errorf("runtime error: %s: %s\n%s", pos, errObj.Message, errObj.StackTrace())
+	}
+	return nil
+}
+
+// newSymbolTable returns the global scope scripts are compiled in: the
+// builtins, then the arguments.
+func newSymbolTable() (*compiler.SymbolTable, compiler.Symbol) {
+	symbolTable := compiler.NewSymbolTableWithBuiltins(object.Builtins)
+	return symbolTable, symbolTable.Define(ArgsName)
+}
+
+func compile(program ast.Node, optimize bool) (*compiler.Bytecode, error) {
+	symbolTable, _ := newSymbolTable()
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	comp.Optimize = optimize
+	if err := comp.Compile(program); err != nil {
+		return nil, fmt.Errorf("compilation failed: %w", err)
+	}
+	return comp.Bytecode(), nil
+}
+
+// RunBytecode executes bytecode returned by Compile on the VM.
+func RunBytecode(bytecode *compiler.Bytecode, args []string) error {
+	_, argsSymbol := newSymbolTable()
+	globals := make([]object.Object, vm.GlobalSize)
+	globals[argsSymbol.Index] = argsArray(args)
+
+	machine := vm.NewWithState(bytecode, globals)
+	if err := machine.Run(); err != nil {
+		if rtErr, ok := err.(*vm.RuntimeError); ok {
+			return fmt.Errorf("runtime error: %w\n%s", err, rtErr.StackTrace())
+		}
+		return fmt.Errorf("runtime error: %w", err)
+	}
+	return nil
+}
+
+func argsArray(args []string) *object.Array {
+	elements := make([]object.Object, len(args))
+	for i, a := range args {
+		elements[i] = &object.String{Value: a}
+	}
+	return &object.Array{Elements: elements}
+}
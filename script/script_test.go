@@ -0,0 +1,93 @@
+package script
+
+import (
+	"bytes"
+	"monkey/compiler"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input         string
+		args          []string
+		expectedError string
+	}{
+		{`let x = 1 + 2; x;`, nil, ""},
+		{`if (len(args) != 2) { 1 + true }`, []string{"a", "b"}, ""},
+		{`if (len(args[1]) != 3) { 1 + true }`, []string{"a", "bcd"}, ""},
+		{`if (len(args[1]) == 3) { 1 + true }`, []string{"a", "bcd"}, "INTEGER"},
+		{`let = 1;`, nil, "parse failed"},
+		{"let a = 1;\nlet b = a / 0;", nil, "test.monkey:2:11: division by zero: 1 / 0"},
+		{"let x = 1;\n  y;", nil, "test.monkey:2:3"},
+		{"let f = fn() { 1 / 0 };\nf();", nil, "\tat f (test.monkey:1:18)\n\tat <main> (test.monkey:2:2)"},
+	}
+
+	for _, useInterpreter := range []bool{false, true} {
+		for _, tt := range tests {
+			err := Run("test.monkey", tt.input, tt.args, useInterpreter)
+			if tt.expectedError == "" {
+				if err != nil {
+					t.Errorf("unexpected error (interpreter=%t): %s", useInterpreter, err)
+				}
+				continue
+			}
+			if err == nil {
+				t.Errorf("expected error (interpreter=%t), got nil", useInterpreter)
+				continue
+			}
+			if !strings.Contains(err.Error(), "test.monkey:") || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("wrong error (interpreter=%t). want=%q, got=%q", useInterpreter, tt.expectedError, err)
+			}
+		}
+	}
+}
+
+func TestRunMacros(t *testing.T) {
+	input := `
+let unless = macro(condition, consequence, alternative) {
+	quote(if (!(unquote(condition))) {
+		unquote(consequence);
+	} else {
+		unquote(alternative);
+	});
+};
+let result = unless(10 > 5, 1, 2);
+if (result != 2) { 1 + true }
+`
+	for _, useInterpreter := range []bool{false, true} {
+		if err := Run("test.monkey", input, nil, useInterpreter); err != nil {
+			t.Errorf("unexpected error (interpreter=%t): %s", useInterpreter, err)
+		}
+	}
+}
+
+func TestRunBytecode(t *testing.T) {
+	input := `
+let f = fn(n) { if (n == 0) { 1 / 0 } else { n } };
+f(len(args) - 1);
+`
+	bytecode, err := Compile("test.monkey", input, true)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := bytecode.Encode(&buf); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	decoded, err := compiler.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	if err := RunBytecode(decoded, []string{"a", "b"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	err = RunBytecode(decoded, []string{"a"})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "test.monkey:2:33: division by zero") {
+		t.Errorf("wrong error. got=%q", err)
+	}
+}
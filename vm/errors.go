@@ -0,0 +1,86 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"monkey/object"
+)
+
+// ErrInstructionLimit is the error of a run that executed more instructions
+// than allowed by SetMaxInstructions.
+var ErrInstructionLimit = errors.New("instruction limit exceeded")
+
+// ErrTimeout is the error of a run that took longer than allowed by
+// SetTimeout.
+var ErrTimeout = errors.New("execution timed out")
+
+// RuntimeError is the error Run returns when execution fails. It carries the
+// stack trace of the frames that were active at the time.
+type RuntimeError struct {
+	Err   error
+	Trace []object.TraceFrame // innermost frame first
+}
+
+func (e *RuntimeError) Error() string {
+	if len(e.Trace) > 0 && e.Trace[0].Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", e.Trace[0].Pos, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// StackTrace renders the error's stack trace, one frame per line.
+func (e *RuntimeError) StackTrace() string { return object.FormatTrace(e.Trace) }
+
+func (vm *VM) stackTrace() []object.TraceFrame {
+	trace := make([]object.TraceFrame, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		f := vm.frames[i]
+		trace = append(trace, object.TraceFrame{Function: f.cl.Fn.Name, Pos: f.Position()})
+	}
+	return trace
+}
+
+// overflowFrames is the number of innermost frames a StackOverflowError keeps.
+const overflowFrames = 10
+
+// StackOverflowError reports that a program exceeded the call depth or the
+// value stack of the VM.
+type StackOverflowError struct {
+	Depth  int                 // call depth at the overflow
+	Frames []object.TraceFrame // the innermost frames, innermost first
+}
+
+func (e *StackOverflowError) Error() string {
+	return fmt.Sprintf("stack overflow: call depth %d", e.Depth)
+}
+
+func (vm *VM) stackOverflow() error {
+	frames := vm.stackTrace()
+	if len(frames) > overflowFrames {
+		frames = frames[:overflowFrames]
+	}
+	return &StackOverflowError{Depth: vm.framesIndex, Frames: frames}
+}
+
+// growStack makes room for size slots on the value stack.
+func (vm *VM) growStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	if size > vm.maxStack {
+		return vm.stackOverflow()
+	}
+	newSize := 2 * len(vm.stack)
+	if newSize < size {
+		newSize = size
+	}
+	if newSize > vm.maxStack {
+		newSize = vm.maxStack
+	}
+	stack := make([]object.Object, newSize)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
@@ -3,6 +3,7 @@ package vm
 import (
 	"monkey/code"
 	"monkey/object"
+	"monkey/token"
 )
 
 type Frame struct {
@@ -18,3 +19,12 @@ func NewFrame(cl *object.Closure, basePointer int) *Frame {
 func (f *Frame) Instructions() code.Instructions {
 	return f.cl.Fn.Instructions
 }
+
+// Position returns the source position of the instruction being executed.
+func (f *Frame) Position() token.Position {
+	ip := f.ip
+	if ip < 0 {
+		ip = 0
+	}
+	return f.cl.Fn.Positions.Lookup(ip)
+}
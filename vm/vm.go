@@ -1,19 +1,22 @@
 package vm
 
 import (
+	"context"
 	"fmt"
 	"monkey/code"
 	"monkey/compiler"
 	"monkey/object"
+	"time"
 )
 
-const StackSize = 2048
+const StackSize = 2048 // the initial size of the value stack
 const GlobalSize = 65536
-const MaxFrames = 1024
+const MaxFrames = 1024           // the default maximum call depth
+const MaxStackSize = 1024 * 1024 // the default maximum size of the value stack
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
 
 type VM struct {
 	constants []object.Object
@@ -23,14 +26,36 @@ type VM struct {
 	globals     []object.Object
 	frames      []*Frame
 	framesIndex int
+	maxDepth    int // maximum number of frames
+	maxStack    int // maximum size of the value stack
+
+	maxInstructions int           // instructions a run may execute, 0 for no limit
+	timeout         time.Duration // how long a run may take, 0 for no limit
+	maxMemory       int64         // bytes a run may allocate, 0 for no limit
+	memory          *object.MemoryQuota
+
+	// state of the current run
+	ctx      context.Context
+	deadline time.Time
+	executed int   // instructions executed so far
+	callErr  error // error of a function called by a builtin
+
+	builtins *object.BuiltinRegistry
 }
 
+// interruptInterval is the number of instructions executed between checks
+// of the context and the timeout of a run. It is a power of two.
+const interruptInterval = 1024
+
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Name:         object.MainFunctionName,
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
-	frames := make([]*Frame, MaxFrames)
-	frames[0] = mainFrame
+	frames := []*Frame{mainFrame}
 
 	return &VM{
 		//instructions: bytecode.Instructions,
@@ -40,9 +65,54 @@ func New(bytecode *compiler.Bytecode) *VM {
 		globals:     make([]object.Object, GlobalSize),
 		frames:      frames,
 		framesIndex: 1,
+		maxDepth:    MaxFrames,
+		maxStack:    MaxStackSize,
+		builtins:    object.Builtins,
+	}
+}
+
+// SetMaxDepth sets the maximum call depth, MaxFrames by default.
+func (vm *VM) SetMaxDepth(depth int) {
+	vm.maxDepth = depth
+}
+
+// SetMaxStackSize sets the maximum number of slots of the value stack,
+// MaxStackSize by default. The stack starts with StackSize slots and grows
+// as the frames and operands of a run need them; running out of slots is a
+// stack overflow like exceeding the call depth.
+func (vm *VM) SetMaxStackSize(size int) {
+	vm.maxStack = size
+	if size < len(vm.stack) {
+		vm.stack = vm.stack[:size]
 	}
 }
 
+// SetMaxInstructions limits the number of instructions each run may execute.
+// A run that exceeds it fails with ErrInstructionLimit. Zero, the default,
+// means no limit.
+func (vm *VM) SetMaxInstructions(n int) {
+	vm.maxInstructions = n
+}
+
+// SetTimeout limits how long each run may take. A run that exceeds it fails
+// with ErrTimeout. Zero, the default, means no limit.
+func (vm *VM) SetTimeout(d time.Duration) {
+	vm.timeout = d
+}
+
+// SetMemoryLimit limits the memory each run may allocate for strings, arrays
+// and hashes, as estimated by object.SizeOf. A run that exceeds it fails with
+// an error wrapping object.ErrMemoryLimit. Zero, the default, means no limit.
+func (vm *VM) SetMemoryLimit(bytes int64) {
+	vm.maxMemory = bytes
+}
+
+// SetBuiltins sets the builtins OpGetBuiltin refers to, object.Builtins by
+// default. They must be those the bytecode was compiled with.
+func (vm *VM) SetBuiltins(builtins *object.BuiltinRegistry) {
+	vm.builtins = builtins
+}
+
 func NewWithState(bytecode *compiler.Bytecode, g []object.Object) *VM {
 	vm := New(bytecode)
 	vm.globals = g
@@ -53,9 +123,17 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIndex] = f
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= vm.maxDepth {
+		return vm.stackOverflow()
+	}
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
 	vm.framesIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -71,10 +149,60 @@ func (vm *VM) StackTop() object.Object {
 }
 
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext is like Run, but stops once ctx is done, failing with the error
+// of ctx.
+func (vm *VM) RunContext(ctx context.Context) error {
+	err := vm.run(ctx)
+	if err != nil {
+		return &RuntimeError{Err: err, Trace: vm.stackTrace()}
+	}
+	return nil
+}
+
+func (vm *VM) run(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	vm.ctx = ctx
+	vm.deadline = time.Time{}
+	if vm.timeout > 0 {
+		vm.deadline = time.Now().Add(vm.timeout)
+	}
+	vm.executed = 0
+	vm.callErr = nil
+	vm.memory = nil
+	if vm.maxMemory > 0 {
+		vm.memory = object.NewMemoryQuota(vm.maxMemory)
+	}
+	return vm.execute(0)
+}
+
+// execute runs instructions until the main function ends or, if base is not
+// zero, until the frame above the first base frames returns.
+func (vm *VM) execute(base int) error {
+	done := vm.ctx.Done()
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	for vm.framesIndex > base && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.executed++
+		if vm.maxInstructions > 0 && vm.executed > vm.maxInstructions {
+			return ErrInstructionLimit
+		}
+		if vm.executed&(interruptInterval-1) == 0 {
+			select {
+			case <-done:
+				return vm.ctx.Err()
+			default:
+			}
+			if !vm.deadline.IsZero() && time.Now().After(vm.deadline) {
+				return ErrTimeout
+			}
+		}
+
 		vm.currentFrame().ip++
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
@@ -92,7 +220,7 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -137,7 +265,12 @@ func (vm *VM) Run() error {
 			localIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 			frame := vm.currentFrame()
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			slot := frame.basePointer + int(localIndex)
+			if cell, ok := vm.stack[slot].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				vm.stack[slot] = vm.pop()
+			}
 		case code.OpGetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
@@ -149,15 +282,31 @@ func (vm *VM) Run() error {
 			localIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 			frame := vm.currentFrame()
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			err := vm.push(deref(vm.stack[frame.basePointer+int(localIndex)]))
+			if err != nil {
+				return err
+			}
+		case code.OpGetLocalCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			slot := vm.currentFrame().basePointer + int(localIndex)
+			cell, ok := vm.stack[slot].(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: vm.stack[slot]}
+				vm.stack[slot] = cell
+			}
+			err := vm.push(cell)
 			if err != nil {
 				return err
 			}
 		case code.OpGetBuiltin:
 			builtIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
-			definition := object.Builtins[builtIndex]
-			err := vm.push(definition.Builtin)
+			builtin := vm.builtins.At(int(builtIndex))
+			if builtin == nil {
+				return fmt.Errorf("unknown builtin %d", builtIndex)
+			}
+			err := vm.push(builtin)
 			if err != nil {
 				return err
 			}
@@ -165,6 +314,9 @@ func (vm *VM) Run() error {
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			if err := vm.memory.Account(array); err != nil {
+				return err
+			}
 			vm.sp = vm.sp - numElements
 			err := vm.push(array)
 			if err != nil {
@@ -177,6 +329,9 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+			if err := vm.memory.Account(array); err != nil {
+				return err
+			}
 			vm.sp = vm.sp - numElements
 			err = vm.push(array)
 			if err != nil {
@@ -197,6 +352,14 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 			frame := vm.popFrame()
@@ -222,10 +385,55 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpGetFree:
-			freeIndex := code.ReadUint16(ins[ip+1:])
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(deref(currentClosure.Free[freeIndex]))
+			if err != nil {
+				return err
+			}
+		case code.OpGetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			err := vm.push(vm.currentFrame().cl.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
+			cell, ok := vm.currentFrame().cl.Free[freeIndex].(*object.Cell)
+			if !ok {
+				return fmt.Errorf("cannot assign to captured function name")
+			}
+			cell.Value = vm.pop()
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+		case code.OpIterator:
+			iterable := vm.pop()
+			it, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("not iterable: %s", iterable.Type())
+			}
+			err := vm.push(it)
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			it := vm.pop().(*object.Iterator)
+			err := vm.executeIterNext(it)
+			if err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
 			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure.Free[freeIndex])
+			err := vm.push(currentClosure)
 			if err != nil {
 				return err
 			}
@@ -235,8 +443,8 @@ func (vm *VM) Run() error {
 }
 
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	if err := vm.growStack(vm.sp + 1); err != nil {
+		return err
 	}
 
 	vm.stack[vm.sp] = o
@@ -262,10 +470,16 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftT == object.INTEGER_OBJ && rightT == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftT == object.STRING_OBJ && rightT == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	case leftT == object.BOOLEAN_OBJ && rightT == object.BOOLEAN_OBJ:
 		return vm.executeBinaryBooleanOperation(op, left, right)
+	case op == code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
+	case op == code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
 	default:
 		return fmt.Errorf("unsupported types for binary operation: %s %s", leftT, rightT)
 	}
@@ -285,11 +499,14 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left object.Object,
 		case code.OpMul:
 			result = leftValue * rightValue
 		case code.OpDiv:
+			if rightValue == 0 {
+				return fmt.Errorf("division by zero: %d / %d", leftValue, rightValue)
+			}
 			result = leftValue / rightValue
 		}
 		err := vm.push(&object.Integer{Value: result})
 		return err
-	case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+	case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
 		var result bool
 		switch op {
 		case code.OpEqual:
@@ -298,6 +515,8 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left object.Object,
 			result = leftValue != rightValue
 		case code.OpGreaterThan:
 			result = leftValue > rightValue
+		case code.OpLessThan:
+			result = leftValue < rightValue
 		}
 		if result {
 			return vm.push(True)
@@ -309,6 +528,33 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left object.Object,
 	}
 }
 
+// executeBinaryFloatOperation handles operands of which at least one is a
+// float; an integer operand is converted to float.
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left object.Object, right object.Object) error {
+	leftValue, _ := object.ToFloat(left)
+	rightValue, _ := object.ToFloat(right)
+	switch op {
+	case code.OpAdd:
+		return vm.push(&object.Float{Value: leftValue + rightValue})
+	case code.OpSub:
+		return vm.push(&object.Float{Value: leftValue - rightValue})
+	case code.OpMul:
+		return vm.push(&object.Float{Value: leftValue * rightValue})
+	case code.OpDiv:
+		return vm.push(&object.Float{Value: leftValue / rightValue})
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+}
+
 func (vm *VM) executeBinaryBooleanOperation(op code.Opcode, left object.Object, right object.Object) error {
 	rightValue := right.(*object.Boolean).Value
 	leftValue := left.(*object.Boolean).Value
@@ -333,7 +579,11 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left object.Object, r
 	leftValue := left.(*object.String).Value
 	switch op {
 	case code.OpAdd:
-		vm.push(&object.String{Value: leftValue + rightValue})
+		result := &object.String{Value: leftValue + rightValue}
+		if err := vm.memory.Account(result); err != nil {
+			return err
+		}
+		vm.push(result)
 	case code.OpEqual:
 		if leftValue == rightValue {
 			vm.push(True)
@@ -354,12 +604,14 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left object.Object, r
 
 func (vm *VM) executeMinusOperation() error {
 	v := vm.pop()
-	vv, ok := v.(*object.Integer)
-	if !ok {
+	switch v := v.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -v.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -v.Value})
+	default:
 		return fmt.Errorf("unsupported types for minus operation: %s", v.Type())
 	}
-	vm.push(&object.Integer{Value: -vv.Value})
-	return nil
 }
 
 func (vm *VM) executeBangOperation() error {
@@ -379,6 +631,25 @@ func (vm *VM) executeBangOperation() error {
 	return nil
 }
 
+// deref returns the value boxed in a cell, or obj itself if it is not a cell.
+func deref(obj object.Object) object.Object {
+	if cell, ok := obj.(*object.Cell); ok {
+		return cell.Value
+	}
+	return obj
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func nativeBoolToBooleanObject(value bool) *object.Boolean {
+	if value {
+		return True
+	}
+	return False
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
 	case *object.Boolean:
@@ -393,23 +664,23 @@ func isTruthy(obj object.Object) bool {
 func (vm *VM) buildArray(beginIndex int, endIndex int) object.Object {
 	elements := make([]object.Object, endIndex-beginIndex)
 	for i := beginIndex; i < endIndex; i++ {
-		elements[i] = vm.stack[i]
+		elements[i-beginIndex] = vm.stack[i]
 	}
 	return &object.Array{Elements: elements}
 }
 
 func (vm *VM) buildHash(beginIndex int, endIndex int) (object.Object, error) {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash((endIndex - beginIndex) / 2)
 	for i := beginIndex; i < endIndex; i += 2 {
 		k := vm.stack[i]
 		v := vm.stack[i+1]
-		kk, ok := k.(object.Hashable)
+		kk, ok := object.HashKeyOf(k)
 		if !ok {
-			return nil, fmt.Errorf("hash key is not Hashable: %T(%+v)", k, k)
+			return nil, fmt.Errorf("unusable as hash key: %s", k.Type())
 		}
-		pairs[kk.HashKey()] = object.HashPair{Key: k, Value: v}
+		hash.Set(kk, object.HashPair{Key: k, Value: v})
 	}
-	return &object.Hash{Pairs: pairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) executeIndexExpression(left object.Object, index object.Object) error {
@@ -418,6 +689,10 @@ func (vm *VM) executeIndexExpression(left object.Object, index object.Object) er
 	switch {
 	case leftT == object.ARRAY_OBJ && indexT == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
+	case leftT == object.TUPLE_OBJ && indexT == object.INTEGER_OBJ:
+		return vm.executeTupleIndex(left, index)
+	case leftT == object.STRING_OBJ && indexT == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
 	case leftT == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 	default:
@@ -436,19 +711,77 @@ func (vm *VM) executeArrayIndex(left object.Object, index object.Object) error {
 	}
 }
 
+func (vm *VM) executeTupleIndex(left object.Object, index object.Object) error {
+	tuple := left.(*object.Tuple)
+	i := index.(*object.Integer).Value
+	if i < 0 || i >= int64(len(tuple.Elements)) {
+		return vm.push(Null)
+	}
+	return vm.push(tuple.Elements[i])
+}
+
+// executeStringIndex pushes the character at an index of a string.
+func (vm *VM) executeStringIndex(left object.Object, index object.Object) error {
+	str := left.(*object.String)
+	char, ok := object.CharAt(str.Value, index.(*object.Integer).Value)
+	if !ok {
+		return vm.push(Null)
+	}
+	return vm.push(&object.String{Value: char})
+}
+
 func (vm *VM) executeHashIndex(left object.Object, index object.Object) error {
 	hash := left.(*object.Hash)
-	key, ok := index.(object.Hashable)
+	key, ok := object.HashKeyOf(index)
 	if !ok {
 		return fmt.Errorf("unusable as hash key: %s", index.Type())
 	}
-	pair, ok := hash.Pairs[key.HashKey()]
+	pair, ok := hash.Pairs[key]
 	if !ok {
 		return vm.push(Null)
 	}
 	return vm.push(pair.Value)
 }
 
+func (vm *VM) executeIterNext(it *object.Iterator) error {
+	element, ok := it.Next()
+	if !ok {
+		return vm.push(False)
+	}
+	err := vm.push(element)
+	if err != nil {
+		return err
+	}
+	return vm.push(True)
+}
+
+func (vm *VM) executeSetIndex(left object.Object, index object.Object, value object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		array := left.(*object.Array)
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(array.Elements)) {
+			return fmt.Errorf("index out of range: %d (len %d)", i, len(array.Elements))
+		}
+		array.Elements[i] = value
+	case left.Type() == object.HASH_OBJ:
+		hash := left.(*object.Hash)
+		hashKey, ok := object.HashKeyOf(index)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		if _, ok := hash.Pairs[hashKey]; !ok {
+			if err := vm.memory.AccountPair(); err != nil {
+				return err
+			}
+		}
+		hash.Set(hashKey, object.HashPair{Key: index, Value: value})
+	default:
+		return fmt.Errorf("index assignment not supported: %s[%s]", left.Type(), index.Type())
+	}
+	return vm.push(value)
+}
+
 func (vm *VM) executeCall(numArgs int) error {
 	callee := vm.stack[vm.sp-1-numArgs]
 	switch callee := callee.(type) {
@@ -466,14 +799,60 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
 	}
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
-	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	if err := vm.growStack(frame.basePointer + cl.Fn.NumLocals); err != nil {
+		return err
+	}
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+	vm.clearLocals(frame)
 	return nil
 }
 
+// executeTailCall calls a closure in place of the one running in the current
+// frame. Other callees, and calls from the main frame, are regular calls.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok || vm.framesIndex == 1 {
+		return vm.executeCall(numArgs)
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+	frame := vm.currentFrame()
+	if err := vm.growStack(frame.basePointer + cl.Fn.NumLocals); err != nil {
+		return err
+	}
+	// move the callee and its arguments over those of the current call
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	vm.sp = frame.basePointer + numArgs
+	frame.cl = cl
+	frame.ip = -1
+	vm.clearLocals(frame)
+	return nil
+}
+
+// clearLocals sets up the locals of a frame entered with its arguments on
+// the stack. The other locals are cleared so that no cell left by an earlier
+// call is reused.
+func (vm *VM) clearLocals(frame *Frame) {
+	for i := vm.sp; i < frame.basePointer+frame.cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = frame.basePointer + frame.cl.Fn.NumLocals
+}
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+	result := builtin.Call(vm, args...)
+	if err := vm.callErr; err != nil {
+		// the frames of the failed call are kept for the stack trace
+		vm.callErr = nil
+		return err
+	}
+	if err := vm.memory.AccountResult(result, args); err != nil {
+		return err
+	}
 	vm.sp = vm.sp - numArgs - 1
 	if result != nil {
 		vm.push(result)
@@ -483,6 +862,37 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	return nil
 }
 
+// CallFunction calls fn for a builtin running on vm, executing a closure
+// until it returns. If the call fails, the run fails with its error once the
+// builtin returns.
+func (vm *VM) CallFunction(fn object.Object, args ...object.Object) object.Object {
+	if vm.callErr != nil {
+		return &object.Error{Message: vm.callErr.Error(), Err: vm.callErr}
+	}
+	base := vm.framesIndex
+	sp := vm.sp
+	err := vm.push(fn)
+	for _, arg := range args {
+		if err != nil {
+			break
+		}
+		err = vm.push(arg)
+	}
+	if err == nil {
+		err = vm.executeCall(len(args))
+	}
+	if err == nil && vm.framesIndex > base {
+		err = vm.execute(base)
+	}
+	if err != nil {
+		vm.callErr = err
+		return &object.Error{Message: err.Error(), Err: err}
+	}
+	result := vm.pop()
+	vm.sp = sp
+	return result
+}
+
 func (vm *VM) pushClosure(constIndex int, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
@@ -1,13 +1,17 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"monkey/ast"
 	"monkey/compiler"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 func parse(input string) *ast.Program {
@@ -28,6 +32,17 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T(%+v)", actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -50,6 +65,10 @@ func testStringObject(expected string, actual object.Object) error {
 	return nil
 }
 
+// testMaxInstructions bounds every test run, so that a runaway program
+// fails its test instead of hanging the suite.
+const testMaxInstructions = 10000000
+
 type vmTestCase struct {
 	input    string
 	expected interface{}
@@ -58,23 +77,28 @@ type vmTestCase struct {
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
-	for _, tt := range tests {
-		program := parse(tt.input)
-		comp := compiler.New()
-		err := comp.Compile(program)
-		if err != nil {
-			t.Fatalf("compilation error: %s", err)
-		}
+	// every case must give the same result with the optimizer enabled
+	for _, optimize := range []bool{false, true} {
+		for _, tt := range tests {
+			program := parse(tt.input)
+			comp := compiler.New()
+			comp.Optimize = optimize
+			err := comp.Compile(program)
+			if err != nil {
+				t.Fatalf("compilation error (optimize=%t): %s", optimize, err)
+			}
 
-		vm := New(comp.Bytecode())
-		err = vm.Run()
+			vm := New(comp.Bytecode())
+			vm.SetMaxInstructions(testMaxInstructions)
+			err = vm.Run()
 
-		if err != nil {
-			t.Fatalf("vm error: %s", err)
-		}
+			if err != nil {
+				t.Fatalf("vm error (optimize=%t): %s", optimize, err)
+			}
 
-		stackElem := vm.LastPoppedStackElem()
-		testExpectedObject(t, tt.expected, stackElem)
+			stackElem := vm.LastPoppedStackElem()
+			testExpectedObject(t, tt.expected, stackElem)
+		}
 	}
 }
 
@@ -86,6 +110,11 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -112,6 +141,19 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 				t.Errorf("testIntegerObject faield in int-array: %s", err)
 			}
 		}
+	case []interface{}:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T(%+v)", actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong number of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElem := range expected {
+			testExpectedObject(t, expectedElem, array.Elements[i])
+		}
 	case map[object.HashKey]int64:
 		hash, ok := actual.(*object.Hash)
 		if !ok {
@@ -170,6 +212,27 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1e3", 1000.0},
+		{"2.5e-1", 0.25},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"10 - 0.25", 9.75},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1.0 == 1", true},
+		{"1 != 1.0", false},
+		{"0.1 + 0.2 == 0.3", false},
+		{"{1: 5}[1.0]", 5},
+	}
+	runVmTests(t, tests)
+}
+
 func TestBooleanExpression(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -197,6 +260,10 @@ func TestBooleanExpression(t *testing.T) {
 		{"!!false", false},
 		{"!!5", true},
 		{"!(if(false){5;})", true},
+		{"[1, [2]] == [1, [2]]", true},
+		{`{"a": 1} != {"a": 1}`, false},
+		{`1 == "1"`, false},
+		{"(if (false) { 1 }) == (if (false) { 2 })", true},
 	}
 	runVmTests(t, tests)
 }
@@ -275,6 +342,11 @@ func TestIndexExpression(t *testing.T) {
 		{"{1:1,2:2}[2]", 2},
 		{"{1:1}[0]", Null},
 		{"{}[0]", Null},
+		{`"abc"[1]`, "b"},
+		{`let s = "abc"; s[len(s) - 1]`, "c"},
+		{`"abc"[3]`, Null},
+		{`"abc"[-1]`, Null},
+		{`""[0]`, Null},
 	}
 	runVmTests(t, tests)
 }
@@ -499,19 +571,385 @@ func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; a = 2; a;", 2},
+		{"let a = 1; a = a + 2;", 3},
+		{"let a = 1; let b = 2; a = b = 5; a + b;", 10},
+		{"let a = 1; let f = fn() { a = 10; }; f(); a;", 10},
+		{"let f = fn() { let a = 1; a = a + 1; a }; f();", 2},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let c = counter(); c(); c(); c();", 3},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let first = counter(); let second = counter(); first(); first(); second();", 1},
+		{`
+		let f = fn() {
+			let n = 0;
+			let inc = fn() { n = n + 1 };
+			let get = fn() { n };
+			inc();
+			inc();
+			get() + n
+		};
+		f();
+		`, 4},
+		{`
+		let f = fn() {
+			let n = 1;
+			let g = fn() { fn() { n = n * 10 } };
+			g()();
+			n
+		};
+		f();
+		`, 10},
+		{"let f = fn() { let i = 0; let sum = 0; while (i < 5) { sum = sum + i; i = i + 1; } sum }; f();", 10},
+		{"let arr = [1, 2, 3]; arr[1] = 20; arr[1];", 20},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"];`, 3},
+	}
+	runVmTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"let arr = [1]; arr[1] = 2;", "1:23: index out of range: 1 (len 1)"},
+		{`let h = {}; h[fn(x) { x }] = 1;`, "1:28: unusable as hash key: COMPILED_FUNCTION_OBJ"},
+		{`let h = {}; h[tuple(fn(x) { x })] = 1;`, "1:35: unusable as hash key: TUPLE"},
+		{`let a = [1]; a[0] = a; let h = {}; h[a] = 1;`, "1:41: unusable as hash key: ARRAY"},
+		{`{tuple([1, fn() { 1 }]): 1}`, "1:1: unusable as hash key: TUPLE"},
+		{`let s = "ab"; s[0] = "c";`, `1:20: index assignment not supported: STRING[INTEGER]`},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error:\n want=%q,\n got =%q", tt.expected, err)
+		}
+	}
+}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 + true;", []string{"at <main> (1:3)"}},
+		{
+			"let inner = fn(x) {\n  x / 0\n};\nlet outer = fn(y) {\n  inner(y + 1) + 1\n};\nouter(1);",
+			[]string{"at inner (2:5)", "at outer (5:8)", "at <main> (7:6)"},
+		},
+		{
+			// a tail call replaces the frame of its caller
+			"let inner = fn(x) {\n  x / 0\n};\nlet outer = fn(y) {\n  inner(y + 1)\n};\nouter(1);",
+			[]string{"at inner (2:5)", "at <main> (7:6)"},
+		},
+		{
+			"let f = fn(a) { a };\nlet g = fn() { f(1, 2) };\ng();",
+			[]string{"at g (2:17)", "at <main> (3:2)"},
+		},
+		{
+			"fn() { -true }();",
+			[]string{"at <anonymous> (1:8)", "at <main> (1:15)"},
+		},
+		{
+			"let fact = fn(n) { if (n == 0) { 1 / 0 } else { n * fact(n - 1) } };\nfact(2);",
+			[]string{"at fact (1:36)", "at fact (1:57)", "at fact (1:57)", "at <main> (2:5)"},
+		},
+		{
+			// a function called by a builtin runs above the builtin's caller
+			"let f = fn(x) {\n  x / 0\n};\nlet g = fn(a) { map(a, f) };\ng([1]);",
+			[]string{"at f (2:5)", "at g (4:20)", "at <main> (5:2)"},
+		},
+		{
+			"map([1], fn(a, b) { a });",
+			[]string{"at <main> (1:4)"},
+		},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("expected *RuntimeError. got=%T(%v)", err, err)
+		}
+		if len(rtErr.Trace) != len(tt.expected) {
+			t.Fatalf("wrong trace length for %q. want=%d, got=%d:\n%s",
+				tt.input, len(tt.expected), len(rtErr.Trace), rtErr.StackTrace())
+		}
+		for i, frame := range rtErr.Trace {
+			if frame.String() != tt.expected[i] {
+				t.Errorf("wrong frame %d. want=%q, got=%q", i, tt.expected[i], frame.String())
+			}
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct {
+		input          string
+		maxDepth       int
+		maxStack       int
+		expectedDepth  int
+		expectedFrames int
+	}{
+		{"let f = fn(n) { -f(n + 1) }; f(0);", 0, 0, MaxFrames, 10},
+		{"let f = fn(n) { -f(n + 1) }; f(0);", 50, 0, 50, 10},
+		{"let f = fn(n) { -f(n + 1) }; f(0);", 5, 0, 5, 5},
+		{"let f = fn(n) { -f(n + 1) }; f(0);", 4000, 0, 4000, 10},
+		{"let f = fn(n) { 1 + f(n + 1) }; f(0);", 0, 0, MaxFrames, 10},
+		// three value stack slots per call exhaust the stack before the
+		// frames, at the main frame plus StackSize/3 calls
+		{"let f = fn(n) { 1 + f(n + 1) }; f(0);", 0, StackSize, StackSize/3 + 1, 10},
+		{"[" + strings.Repeat("1, ", StackSize) + "1]", 0, StackSize, 1, 1},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if tt.maxDepth != 0 {
+			vm.SetMaxDepth(tt.maxDepth)
+		}
+		if tt.maxStack != 0 {
+			vm.SetMaxStackSize(tt.maxStack)
+		}
+		err := vm.Run()
+		var overflow *StackOverflowError
+		if !errors.As(err, &overflow) {
+			t.Fatalf("expected *StackOverflowError. got=%T(%v)", err, err)
+		}
+		if overflow.Depth != tt.expectedDepth {
+			t.Errorf("wrong depth. want=%d, got=%d", tt.expectedDepth, overflow.Depth)
+		}
+		if len(overflow.Frames) != tt.expectedFrames {
+			t.Errorf("wrong number of frames. want=%d, got=%d", tt.expectedFrames, len(overflow.Frames))
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("stack overflow: call depth %d", tt.expectedDepth)) {
+			t.Errorf("wrong error message. got=%q", err)
+		}
+	}
+}
+
+func TestDeepRecursionWithinMaxDepth(t *testing.T) {
+	program := parse("let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(3000);")
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	vm.SetMaxDepth(5000)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 3000, vm.LastPoppedStackElem())
+}
+
+func TestDeepRecursionWithLocals(t *testing.T) {
+	program := parse(`
+	let f = fn(n) {
+		let a = n; let b = a + 1; let c = b + 1; let d = c + 1; let e = d + 1;
+		if (n == 0) { 0 } else { e - d + f(n - 1) }
+	};
+	f(9990);`)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	vm.SetMaxDepth(10000)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 9990, vm.LastPoppedStackElem())
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } };
+			sum(100000, 0);
+			`,
+			expected: 5000050000,
+		},
+		{
+			input: `
+			let odd = fn(n, even) { if (n == 0) { false } else { even(n - 1, odd) } };
+			let even = fn(n, odd) { if (n == 0) { true } else { odd(n - 1, even) } };
+			even(10001, odd);
+			`,
+			expected: false,
+		},
+		{
+			input:    `let f = fn(arr) { len(arr) }; f([1, 2, 3]);`,
+			expected: 3,
+		},
+		{
+			input: `
+			let count = fn(n) {
+				let total = 0;
+				let loop = fn(i) { if (i == n) { total } else { total = total + i; loop(i + 1) } };
+				loop(0)
+			};
+			count(20000);
+			`,
+			expected: 199990000,
+		},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		// tail calls reuse the frame of their caller, so deep tail
+		// recursion fits in a handful of frames
+		vm.SetMaxDepth(10)
+		vm.SetMaxInstructions(testMaxInstructions)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestRunBudgets(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelExpired()
+
+	tests := []struct {
+		input           string
+		maxInstructions int
+		timeout         time.Duration
+		ctx             context.Context
+		expected        error
+	}{
+		{"while (true) {}", 1000, 0, context.Background(), ErrInstructionLimit},
+		{"let f = fn() { f() }; f();", 1000, 0, context.Background(), ErrInstructionLimit},
+		{"while (true) {}", 0, 10 * time.Millisecond, context.Background(), ErrTimeout},
+		{"while (true) {}", 0, 0, canceled, context.Canceled},
+		{"let f = fn() { f() }; f();", 0, 0, expired, context.DeadlineExceeded},
+		{"let i = 0; while (i < 10) { i = i + 1 }; i", 1000, time.Minute, context.Background(), nil},
+		// budgets cover the functions called by builtins
+		{"map([1], fn(x) { while (true) {} })", 1000, 0, context.Background(), ErrInstructionLimit},
+		{"each([1, 2], fn(x) { while (true) {} })", 0, 10 * time.Millisecond, context.Background(), ErrTimeout},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetMaxInstructions(tt.maxInstructions)
+		vm.SetTimeout(tt.timeout)
+		err := vm.RunContext(tt.ctx)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("wrong error for %q. want=%v, got=%v", tt.input, tt.expected, err)
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(*RuntimeError); !ok {
+				t.Errorf("expected *RuntimeError. got=%T(%v)", err, err)
+			}
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []struct {
+		input    string
+		limit    int64
+		exceeded bool
+	}{
+		{"let a = []; while (true) { a = push(a, 1) }", 1 << 20, true},
+		{`let s = "x"; while (true) { s = s + s }`, 1 << 20, true},
+		{"let h = {}; let i = 0; while (true) { h[i] = i; i = i + 1 }", 1 << 20, true},
+		{"while (true) { [1, 2, 3] }", 1 << 20, true},
+		{"let a = [1, 2, 3]; push(a, 4)", 1 << 10, false},
+		// the elements a builtin returns are not allocated again
+		{`let a = ["abc"]; let i = 0; while (i < 10000) { first(a); last(a); i = i + 1 }`, 1 << 10, false},
+		{"let h = {1: 2}; let i = 0; while (i < 10000) { h[1] = i; i = i + 1 }", 1 << 10, false},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetMaxInstructions(testMaxInstructions)
+		vm.SetMemoryLimit(tt.limit)
+		err := vm.Run()
+		if tt.exceeded != errors.Is(err, object.ErrMemoryLimit) {
+			t.Errorf("wrong error for %q. got=%v", tt.input, err)
+		}
+		if tt.exceeded && err != nil && !strings.Contains(err.Error(), fmt.Sprintf("memory limit exceeded: allocated more than %d bytes", tt.limit)) {
+			t.Errorf("wrong error message. got=%q", err)
+		}
+	}
+}
+
+func TestStackOverflowTrace(t *testing.T) {
+	program := parse("let f = fn(n) { -f(n + 1) }; f(0);")
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError. got=%T(%v)", err, err)
+	}
+	if len(rtErr.Trace) != MaxFrames {
+		t.Errorf("wrong trace length. want=%d, got=%d", MaxFrames, len(rtErr.Trace))
+	}
+	lines := strings.Split(rtErr.StackTrace(), "\n")
+	if len(lines) != 21 {
+		t.Fatalf("wrong number of trace lines. want=21, got=%d", len(lines))
+	}
+	if lines[15] != fmt.Sprintf("\t... %d more frames", MaxFrames-20) {
+		t.Errorf("wrong elision line. got=%q", lines[15])
+	}
+	if lines[20] != "\tat <main> (1:31)" {
+		t.Errorf("wrong outermost frame. got=%q", lines[20])
+	}
+}
+
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
 			input:    `fn(){1;}(1);`,
-			expected: `wrong number of arguments: want=0, got=1`,
+			expected: `1:9: wrong number of arguments: want=0, got=1`,
 		},
 		{
 			input:    `fn(a){a;}();`,
-			expected: `wrong number of arguments: want=1, got=0`,
+			expected: `1:10: wrong number of arguments: want=1, got=0`,
 		},
 		{
 			input:    `fn(a, b){a + b;}(1);`,
-			expected: `wrong number of arguments: want=2, got=1`,
+			expected: `1:17: wrong number of arguments: want=2, got=1`,
+		},
+		{
+			input:    `10 / (5 - 5)`,
+			expected: `1:4: division by zero: 10 / 0`,
 		},
 	}
 	for _, tt := range tests {
@@ -532,6 +970,185 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	}
 }
 
+func TestRegisteredBuiltins(t *testing.T) {
+	builtins := object.NewBuiltinRegistry()
+	_, err := builtins.Register("double", 1, func(args ...object.Object) object.Object {
+		return &object.Integer{Value: 2 * args[0].(*object.Integer).Value}
+	})
+	if err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+
+	tests := []vmTestCase{
+		{"double(21)", 42},
+		{"let f = fn(x) { double(x) + len([x]) }; f(4)", 9},
+		{"double(1, 2)", &object.Error{Message: "wrong number of arguments. got=2, want=1"}},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.NewWithState(compiler.NewSymbolTableWithBuiltins(builtins), []object.Object{})
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetBuiltins(builtins)
+		vm.SetMaxInstructions(testMaxInstructions)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{"map([1, 2, 3], fn(x) { x * 2 })", []int{2, 4, 6}},
+		{"map([], fn(x) { x })", []int{}},
+		{"let n = 10; map([1, 2], fn(x) { x + n })", []int{11, 12}},
+		{"map([[1], [1, 2]], len)", []int{1, 2}},
+		{"map([[1, 2]], fn(a) { map(a, fn(x) { -x }) })[0]", []int{-1, -2}},
+		{"filter([1, 2, 3, 4], fn(x) { x > 2 })", []int{3, 4}},
+		{"filter([1, 2], fn(x) { if (x > 1) { 0 } })", []int{2}},
+		{"reduce([1, 2, 3], 10, fn(acc, x) { acc + x })", 16},
+		{"reduce([], 10, fn(acc, x) { acc + x })", 10},
+		{"let sum = 0; each([1, 2, 3], fn(x) { sum = sum + x }); sum", 6},
+		{"each([1], fn(x) { x })", Null},
+		{"any([1, 2], fn(x) { x > 1 })", true},
+		{"any([], fn(x) { true })", false},
+		{"all([1, 2], fn(x) { x > 1 })", false},
+		{"all([], fn(x) { false })", true},
+		{"let calls = 0; any([1, 2, 3], fn(x) { calls = calls + 1; x == 2 }); calls", 2},
+		{"sort_by([3, 1, 2], fn(x) { x })", []int{1, 2, 3}},
+		{"sort_by([3, 1, 2], fn(x) { -x })", []int{3, 2, 1}},
+		{"sort_by([1, 2, 3], fn(x) { x / 2 })", []int{1, 2, 3}},
+		{"sort_by([2, 1], fn(x) { 1.5 * x })", []int{1, 2}},
+		{`sort_by([[1, 2], [3]], fn(a) { if (len(a) > 1) { "b" } else { "a" } })[0]`, []int{3}},
+		{"let fact = fn(n) { reduce(rest(push([0], n)), 1, fn(acc, x) { if (x > 1) { acc * x * fact(x - 1) } else { acc } }) }; fact(4)", 24},
+		{"map(1, len)", &object.Error{Message: "argument to `map` must be ARRAY, got INTEGER"}},
+		{"filter([1], 1)", &object.Error{Message: "argument to `filter` must be a function, got INTEGER"}},
+		{"map([1], len)", &object.Error{Message: "argument to `len` not supported, got INTEGER"}},
+		{`sort_by([1, 2], fn(x) { if (x > 1) { "a" } else { x } })`, &object.Error{Message: "`sort_by` keys cannot be compared: INTEGER and STRING"}},
+		{"sort_by([1], fn(x) { [x] })", &object.Error{Message: "`sort_by` keys must be numbers or strings, got ARRAY"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`len(split("a,b,,c", ","))`, 4},
+		{`join(split("a b c", " "), "-")`, "a-b-c"},
+		{`join(split("abc", ""), ".")`, "a.b.c"},
+		{`join([], ",")`, ""},
+		{`trim("  a b  ")`, "a b"},
+		{`contains("monkey", "key")`, true},
+		{`contains("monkey", "ape")`, false},
+		{`starts_with("monkey", "mon")`, true},
+		{`ends_with("monkey", "mon")`, false},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`upper("Monkey")`, "MONKEY"},
+		{`lower("Monkey")`, "monkey"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", 0)`, ""},
+		{`index_of("monkey", "key")`, 3},
+		{`index_of("monkey", "ape")`, -1},
+		{`substring("monkey", 1, 3)`, "on"},
+		{`substring("monkey", -5, 99)`, "monkey"},
+		{`substring("monkey", 4, 2)`, ""},
+		{`ord("a")`, 97},
+		{`ord("é")`, 233},
+		{`chr(97) + chr(233)`, "aé"},
+		{`join(map(split("ab", ""), fn(c) { chr(ord(c) + 1) }), "")`, "bc"},
+		{`split(1, ",")`, &object.Error{Message: "argument to `split` must be STRING, got INTEGER"}},
+		{`join([1], ",")`, &object.Error{Message: "`join` of a non-string element: INTEGER"}},
+		{`repeat("a", -1)`, &object.Error{Message: "negative `repeat` count: -1"}},
+		{`repeat("ab", 1000000000)`, &object.Error{Message: "`repeat` result longer than 1073741824 bytes"}},
+		{`ord("")`, &object.Error{Message: "`ord` of an empty string"}},
+		{`chr(-1)`, &object.Error{Message: "invalid character code: -1"}},
+		{`chr(55296)`, &object.Error{Message: "invalid character code: 55296"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`keys({3: 1, 1: 2, 2: 3})`, []int{3, 1, 2}},
+		{`values({3: 1, 1: 2, 2: 3})`, []int{1, 2, 3}},
+		{`let h = {1: 1}; h[0] = 2; h[1] = 3; keys(h)`, []int{1, 0}},
+		{`keys({})`, []int{}},
+		{`has({"a": if (false) { 1 }}, "a")`, true},
+		{`has({"a": if (false) { 1 }}, "b")`, false},
+		{`let h = {1: 1, 2: 2, 3: 3}; delete(h, 2)`, true},
+		{`let h = {1: 1, 2: 2, 3: 3}; delete(h, 2); keys(h)`, []int{1, 3}},
+		{`let h = {1: 1}; delete(h, 2)`, false},
+		{`let h = {1: 1, 2: 2}; delete(h, 1); h[1] = 1; keys(h)`, []int{2, 1}},
+		{`keys(merge({1: 1, 2: 2}, {3: 3, 1: 4}))`, []int{1, 2, 3}},
+		{`values(merge({1: 1, 2: 2}, {3: 3, 1: 4}))`, []int{4, 2, 3}},
+		{`let a = {1: 1}; merge(a, {2: 2}); keys(a)`, []int{1}},
+		{`let s = 0; for (k in {3: 0, 1: 0, 2: 0}) { s = s * 10 + k }; s`, 312},
+		{`keys([])`, &object.Error{Message: "argument to `keys` must be HASH, got ARRAY"}},
+		{`has({}, [1, fn() {}])`, &object.Error{Message: "unusable as hash key: ARRAY"}},
+		{`has({}, tuple({}))`, &object.Error{Message: "unusable as hash key: TUPLE"}},
+		{`merge({}, 1)`, &object.Error{Message: "argument to `merge` must be HASH, got INTEGER"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []vmTestCase{
+		{`let grid = {}; grid[[1, 2]] = 3; grid[[1, 2]]`, 3},
+		{`{[1, 2]: 3}[tuple(1, 2)]`, 3},
+		{`{tuple(1, [2, 3]): 4}[[1, [2, 3]]]`, 4},
+		{`{[1, 2]: 3}[[2, 1]]`, Null},
+		{`{[1]: 1, [1.0]: 2}[[1]]`, 2},
+		{`len(keys({[1]: 1, ["1"]: 2, [[1]]: 3, []: 4}))`, 4},
+		{`let k = [1, 2]; let h = {}; h[k] = 1; k[0] = 5; [h[[1, 2]], h[[5, 2]]]`, []interface{}{1, Null}},
+		{`let k = [1, 2]; let h = {k: 1}; k[0] = 5; keys(h)[0][0]`, 1},
+		{`let t = tuple(1, [2]); [len(t), t[0], t[1][0], t[2]]`, []interface{}{2, 1, 2, Null}},
+		{`let s = 0; for (x in tuple(1, 2, 3)) { s = s + x }; s`, 6},
+		{
+			`let memo = {};
+			let paths = fn(x, y) {
+				if (x == 0) { return 1 }
+				if (y == 0) { return 1 }
+				if (has(memo, [x, y])) { return memo[[x, y]] }
+				let n = paths(x - 1, y) + paths(x, y - 1);
+				memo[[x, y]] = n;
+				n
+			};
+			paths(16, 16)`,
+			601080390,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestSelfReferencingValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // inspected result
+	}{
+		{`let a = [1]; a[0] = a; a`, "[[...]]"},
+		{`let a = [1, 2]; a[1] = [a]; a`, "[1, [[...]]]"},
+		{`let h = {"a": 1}; h["self"] = h; h`, "{a:1, self:{...}}"},
+		{`let a = [1]; a[0] = a; tuple(a)`, "(((...),),)"},
+		{`let a = [1]; let b = [a, a]; b`, "[[1], [1]]"},
+		{`let a = [1]; a[0] = a; has({}, tuple(a))`, "ERROR: unusable as hash key: TUPLE"},
+	}
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{`len("")`, 0},
@@ -560,7 +1177,7 @@ func TestBuiltinFunctions(t *testing.T) {
 				Message: "argument to `first` must be ARRAY, got INTEGER",
 			},
 		},
-		// {`last([1,2,3])`, 3},
+		{`last([1,2,3])`, 3},
 		{`last([])`, Null},
 		{
 			`last(1)`,
@@ -568,7 +1185,7 @@ func TestBuiltinFunctions(t *testing.T) {
 				Message: "argument to `last` must be ARRAY, got INTEGER",
 			},
 		},
-		// {`rest([1,2,3])`, []int{2, 3}},
+		{`rest([1,2,3])`, []int{2, 3}},
 		{`rest([])`, Null},
 		{`push([], 1)`, []int{1}},
 		{
@@ -596,3 +1213,118 @@ func TestClosures(t *testing.T) {
 	}
 	runVmTests(t, tests)
 }
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			countDown(1);
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) {
+						return 0;
+					} else {
+						countDown(x - 1);
+					}
+				};
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let map = fn(arr, f) {
+				let iter = fn(arr, accumulated) {
+					if (len(arr) == 0) {
+						accumulated
+					} else {
+						iter(rest(arr), push(accumulated, f(first(arr))));
+					}
+				};
+				iter(arr, []);
+			};
+			map([1, 2, 3], fn(x) { x * 2 });
+			`,
+			expected: []int{2, 4, 6},
+		},
+		{
+			input: `
+			let countDown = fn(x) {
+				let step = fn() { countDown(x - 1) };
+				if (x == 0) { 0 } else { step() }
+			};
+			countDown(3);
+			`,
+			expected: 0,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let fibonacci = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					if (x == 1) {
+						return 1;
+					} else {
+						fibonacci(x - 1) + fibonacci(x - 2);
+					}
+				}
+			};
+			fibonacci(15);
+			`,
+			expected: 610,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{`let i = 0; let sum = 0; while (i < 5) { let sum = sum + i; let i = i + 1; } sum;`, 10},
+		{`let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; } sum;`, 6},
+		{`let n = 0; for (k in {"a": 1, "b": 2}) { let n = n + 1; } n;`, 2},
+		{`let s = ""; for (c in "abc") { let s = c + s; } s;`, "cba"},
+		{`let i = 0; while (true) { if (i > 3) { break; } let i = i + 1; } i;`, 4},
+		{`let sum = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let sum = sum + x; } sum;`, 8},
+		{`let f = fn(arr) { for (x in arr) { if (x > 1) { return x; } } -1 }; f([0, 5, 7]);`, 5},
+		{`let f = fn(arr) { for (x in arr) { if (x > 9) { return x; } } -1 }; f([0, 5, 7]);`, -1},
+		{`
+		let count = fn(n) {
+			let total = 0;
+			for (x in [1, 2, 3]) {
+				for (y in [1, 2, 3]) {
+					if (y > x) { break; }
+					let total = total + 1;
+				}
+			}
+			total
+		};
+		count(0);
+		`, 6},
+		{`if (true) { while (false) {} }`, Null},
+		{`let i = 0; while (i < 3) { let i = i + 1; }; i;`, 3},
+		{`let s = ""; for (c in "héllo") { let s = s + c + "."; } s;`, "h.é.l.l.o."},
+		{`let s = ""; for (k in {"b": 1, "a": 2, "c": 3}) { let s = s + k; } s;`, "bac"},
+	}
+	runVmTests(t, tests)
+}